@@ -0,0 +1,293 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// fetchedFeed holds a feed fetched from a Nitter instance.
+type fetchedFeed struct {
+	body     []byte       // raw feed returned by instance
+	feed     *gofeed.Feed // parsed from body; must not be modified
+	instance *url.URL     // instance from which feed was fetched
+	loc      *url.URL     // final location after redirects
+	minID    string       // Min-Id header value
+	fetched  time.Time    // time at which feed was fetched
+}
+
+// cacheState describes the freshness of a cached feed.
+type cacheState int
+
+const (
+	cacheMiss    cacheState = iota // no usable entry
+	cacheFresh                     // entry can be served as-is
+	cacheStale                     // entry can be served but should be refreshed
+	cacheExpired                   // entry should only be served if fetching fails
+)
+
+// feedCache is an in-memory cache of feeds fetched from Nitter instances.
+// It's safe for concurrent use.
+type feedCache struct {
+	dir    string     // directory where entries are persisted (empty if disabled)
+	diskMu sync.Mutex // serializes writes to dir; held without mu so gets aren't blocked
+
+	mu         sync.Mutex
+	ttl        time.Duration // entries are fresh for this long (caching is disabled if 0)
+	stale      time.Duration // entries can be served while refreshing for this long after ttl
+	maxSize    int           // max number of entries
+	entries    map[string]*fetchedFeed
+	refreshing map[string]struct{} // keys being refreshed in the background
+}
+
+// newFeedCache returns a new feedCache.
+// If dir is non-empty, entries are written to it and previously-written entries are loaded from it.
+func newFeedCache(ttl, stale time.Duration, maxSize int, dir string) (*feedCache, error) {
+	c := &feedCache{
+		ttl:        ttl,
+		stale:      stale,
+		maxSize:    maxSize,
+		dir:        dir,
+		entries:    make(map[string]*fetchedFeed),
+		refreshing: make(map[string]struct{}),
+	}
+	if c.dir != "" {
+		if err := os.MkdirAll(c.dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// cacheKey returns the key used to cache user's feed fetched with the supplied query.
+func cacheKey(user, query string) string {
+	if query == "" {
+		return user
+	}
+	return user + "?" + query
+}
+
+// setLimits updates c's limits, e.g. after the configuration has been reloaded.
+// Entries are only evicted when new entries are added.
+func (c *feedCache) setLimits(ttl, stale time.Duration, maxSize int) {
+	c.mu.Lock()
+	c.ttl, c.stale, c.maxSize = ttl, stale, maxSize
+	c.mu.Unlock()
+}
+
+// get returns the entry for key and its state.
+func (c *feedCache) get(key string, now time.Time) (*fetchedFeed, cacheState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ff := c.entries[key]
+	if ff == nil || c.ttl <= 0 {
+		return nil, cacheMiss
+	}
+	switch age := now.Sub(ff.fetched); {
+	case age < c.ttl:
+		return ff, cacheFresh
+	case age < c.ttl+c.stale:
+		return ff, cacheStale
+	default:
+		return ff, cacheExpired
+	}
+}
+
+// set saves ff under key, evicting the oldest entry if the cache is full.
+func (c *feedCache) set(key string, ff *fetchedFeed) {
+	c.mu.Lock()
+	if c.ttl <= 0 {
+		c.mu.Unlock()
+		return
+	}
+	var oldKey string
+	if _, ok := c.entries[key]; !ok && c.maxSize > 0 && len(c.entries) >= c.maxSize {
+		var oldTime time.Time
+		for k, e := range c.entries {
+			if oldKey == "" || e.fetched.Before(oldTime) {
+				oldKey, oldTime = k, e.fetched
+			}
+		}
+		delete(c.entries, oldKey)
+	}
+	c.entries[key] = ff
+	c.mu.Unlock()
+
+	if c.dir == "" {
+		return
+	}
+
+	// Update the disk outside of mu. Concurrent calls can reach this point in any order,
+	// so only write ff if it's still key's entry (a later call will write a newer one)
+	// and only remove oldKey's file if it hasn't been re-added.
+	c.diskMu.Lock()
+	defer c.diskMu.Unlock()
+	if oldKey != "" && !c.has(oldKey, nil) {
+		if err := os.Remove(c.path(oldKey)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed removing cached %v: %v", oldKey, err)
+		}
+	}
+	if c.has(key, ff) {
+		if err := c.write(key, ff); err != nil {
+			log.Printf("Failed writing cached %v: %v", key, err)
+		}
+	}
+}
+
+// has returns true if key's entry is ff, or if ff is nil and key has any entry.
+func (c *feedCache) has(key string, ff *fetchedFeed) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	return ok && (ff == nil || e == ff)
+}
+
+// startRefresh marks key as being refreshed in the background.
+// False is returned if key is already being refreshed.
+func (c *feedCache) startRefresh(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.refreshing[key]; ok {
+		return false
+	}
+	c.refreshing[key] = struct{}{}
+	return true
+}
+
+// finishRefresh should be called after a refresh started via startRefresh completes.
+func (c *feedCache) finishRefresh(key string) {
+	c.mu.Lock()
+	delete(c.refreshing, key)
+	c.mu.Unlock()
+}
+
+// diskEntry is the JSON representation of a fetchedFeed written to disk.
+type diskEntry struct {
+	Key      string    `json:"key"`
+	Body     []byte    `json:"body"`
+	Instance string    `json:"instance"`
+	Loc      string    `json:"loc"`
+	MinID    string    `json:"minId"`
+	Fetched  time.Time `json:"fetched"`
+}
+
+const diskEntryExt = ".json"
+
+// path returns the path of the file used to persist key's entry.
+func (c *feedCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+diskEntryExt)
+}
+
+// write atomically writes ff to disk under key.
+func (c *feedCache) write(key string, ff *fetchedFeed) error {
+	de := diskEntry{Key: key, Body: ff.body, MinID: ff.minID, Fetched: ff.fetched}
+	if ff.instance != nil {
+		de.Instance = ff.instance.String()
+	}
+	if ff.loc != nil {
+		de.Loc = ff.loc.String()
+	}
+	b, err := json.Marshal(&de)
+	if err != nil {
+		return err
+	}
+	tf, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tf.Write(b); err != nil {
+		tf.Close()
+		os.Remove(tf.Name())
+		return err
+	}
+	if err := tf.Close(); err != nil {
+		os.Remove(tf.Name())
+		return err
+	}
+	return os.Rename(tf.Name(), c.path(key))
+}
+
+// load reads previously-written entries from c.dir.
+// Only the newest c.maxSize entries are kept.
+func (c *feedCache) load() error {
+	fis, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	var loaded []string
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || !strings.HasSuffix(fi.Name(), diskEntryExt) {
+			continue
+		}
+		p := filepath.Join(c.dir, fi.Name())
+		key, ff, err := readDiskEntry(p)
+		if err != nil {
+			log.Printf("Failed loading cached feed from %v: %v", p, err)
+			continue
+		}
+		c.entries[key] = ff
+		loaded = append(loaded, key)
+	}
+
+	if c.maxSize > 0 && len(loaded) > c.maxSize {
+		sort.Slice(loaded, func(i, j int) bool {
+			return c.entries[loaded[i]].fetched.After(c.entries[loaded[j]].fetched)
+		})
+		for _, key := range loaded[c.maxSize:] {
+			delete(c.entries, key)
+			os.Remove(c.path(key))
+		}
+	}
+	log.Printf("Loaded %v cached feed(s) from %v", len(c.entries), c.dir)
+	return nil
+}
+
+// readDiskEntry reads and parses an entry written by feedCache.write.
+func readDiskEntry(p string) (key string, ff *fetchedFeed, err error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", nil, err
+	}
+	var de diskEntry
+	if err := json.Unmarshal(b, &de); err != nil {
+		return "", nil, err
+	}
+	if de.Key == "" {
+		return "", nil, errors.New("missing key")
+	}
+	ff = &fetchedFeed{body: de.Body, minID: de.MinID, fetched: de.Fetched}
+	if de.Instance != "" {
+		if ff.instance, err = url.Parse(de.Instance); err != nil {
+			return "", nil, err
+		}
+	}
+	if de.Loc != "" {
+		if ff.loc, err = url.Parse(de.Loc); err != nil {
+			return "", nil, err
+		}
+	}
+	if ff.feed, err = gofeed.NewParser().ParseString(string(de.Body)); err != nil {
+		return "", nil, err
+	}
+	return de.Key, ff, nil
+}
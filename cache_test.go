@@ -0,0 +1,159 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFeedCache(t *testing.T) {
+	const (
+		ttl   = time.Minute
+		stale = time.Hour
+	)
+	c, err := newFeedCache(ttl, stale, 2, "")
+	if err != nil {
+		t.Fatal("Failed creating cache:", err)
+	}
+	t0 := time.Unix(1000, 0)
+
+	if ff, state := c.get("a", t0); ff != nil || state != cacheMiss {
+		t.Errorf("get(%q) on empty cache = %v, %v; want nil, %v", "a", ff, state, cacheMiss)
+	}
+
+	a := &fetchedFeed{fetched: t0}
+	c.set("a", a)
+	for _, tc := range []struct {
+		now  time.Time
+		want cacheState
+	}{
+		{t0, cacheFresh},
+		{t0.Add(ttl - time.Second), cacheFresh},
+		{t0.Add(ttl), cacheStale},
+		{t0.Add(ttl + stale - time.Second), cacheStale},
+		{t0.Add(ttl + stale), cacheExpired},
+	} {
+		if ff, state := c.get("a", tc.now); ff != a || state != tc.want {
+			t.Errorf("get(%q) at %v = %v, %v; want %v, %v", "a", tc.now, ff, state, a, tc.want)
+		}
+	}
+
+	// Adding a third entry should evict the oldest one.
+	c.set("b", &fetchedFeed{fetched: t0.Add(time.Second)})
+	c.set("c", &fetchedFeed{fetched: t0.Add(2 * time.Second)})
+	if ff, _ := c.get("a", t0); ff != nil {
+		t.Errorf("get(%q) returned evicted entry", "a")
+	}
+	for _, key := range []string{"b", "c"} {
+		if ff, _ := c.get(key, t0); ff == nil {
+			t.Errorf("get(%q) didn't return entry", key)
+		}
+	}
+
+	if !c.startRefresh("b") {
+		t.Errorf("startRefresh(%q) = false; want true", "b")
+	} else if c.startRefresh("b") {
+		t.Errorf("Second startRefresh(%q) = true; want false", "b")
+	}
+	c.finishRefresh("b")
+	if !c.startRefresh("b") {
+		t.Errorf("startRefresh(%q) after finishRefresh = false; want true", "b")
+	}
+}
+
+func TestFeedCache_Dir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nitter-rss-proxy.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const (
+		key  = "someuser?max_position=123"
+		body = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"><channel><title>Some User</title><item><title>Hi</title></item></channel></rss>`
+	)
+	loc, _ := url.Parse("https://nitter.example.org/someuser/rss")
+	fetched := time.Unix(1000, 0).UTC()
+
+	c, err := newFeedCache(time.Minute, time.Hour, 10, dir)
+	if err != nil {
+		t.Fatal("Failed creating cache:", err)
+	}
+	c.set(key, &fetchedFeed{body: []byte(body), loc: loc, minID: "456", fetched: fetched})
+
+	// A new cache using the same directory should load the saved entry.
+	if c, err = newFeedCache(time.Minute, time.Hour, 10, dir); err != nil {
+		t.Fatal("Failed recreating cache:", err)
+	}
+	ff, _ := c.get(key, fetched)
+	if ff == nil {
+		t.Fatalf("get(%q) didn't return entry loaded from disk", key)
+	}
+	if string(ff.body) != body {
+		t.Errorf("Loaded body is %q; want %q", ff.body, body)
+	}
+	if ff.loc == nil || ff.loc.String() != loc.String() {
+		t.Errorf("Loaded location is %v; want %v", ff.loc, loc)
+	}
+	if ff.minID != "456" {
+		t.Errorf("Loaded min ID is %q; want %q", ff.minID, "456")
+	}
+	if !ff.fetched.Equal(fetched) {
+		t.Errorf("Loaded fetch time is %v; want %v", ff.fetched, fetched)
+	}
+	if ff.feed == nil || ff.feed.Title != "Some User" {
+		t.Errorf("Loaded feed is %+v; want title %q", ff.feed, "Some User")
+	}
+}
+
+func TestFeedCache_DirUnlocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nitter-rss-proxy.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const body = `<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"><channel></channel></rss>`
+	now := time.Unix(1000, 0).UTC()
+	c, err := newFeedCache(time.Minute, time.Hour, 1, dir)
+	if err != nil {
+		t.Fatal("Failed creating cache:", err)
+	}
+	c.set("a", &fetchedFeed{body: []byte(body), fetched: now})
+
+	// Block disk writes. Entries should still be readable while sets are waiting on the disk.
+	c.diskMu.Lock()
+	done := make(chan struct{})
+	go func() {
+		c.set("b", &fetchedFeed{body: []byte(body), fetched: now.Add(time.Second)})
+		c.set("b", &fetchedFeed{body: []byte(body), fetched: now.Add(2 * time.Second)})
+		close(done)
+	}()
+	for {
+		if ff, _ := c.get("b", now); ff != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.diskMu.Unlock()
+	<-done
+
+	// Only the newest entry should be on disk.
+	if c, err = newFeedCache(time.Minute, time.Hour, 10, dir); err != nil {
+		t.Fatal("Failed recreating cache:", err)
+	}
+	if ff, _ := c.get("a", now); ff != nil {
+		t.Error("Evicted entry was loaded from disk")
+	}
+	if ff, _ := c.get("b", now); ff == nil {
+		t.Error("Entry wasn't loaded from disk")
+	} else if want := now.Add(2 * time.Second); !ff.fetched.Equal(want) {
+		t.Errorf("Loaded entry was fetched at %v; want %v", ff.fetched, want)
+	}
+}
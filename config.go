@@ -0,0 +1,369 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config describes a YAML configuration file, e.g.
+//
+//	addr: localhost:8080
+//	instances:
+//	  - https://nitter.example.org
+//	  - https://nitter.example.net
+//	format: atom
+//	rewrite_target: x.com
+//	timeout: 10s
+//	cache:
+//	  ttl: 5m
+//	  dir: /var/cache/nitter-rss-proxy
+//	merge:
+//	  max_items: 50
+//	feeds:
+//	  someuser:
+//	    format: json
+//	    title: Some User's tweets
+//	    retweets: annotate
+//	    quotes: 5
+//	    threads: true
+//	    image_size: large
+//	    filter:
+//	      exclude: (?i)giveaway
+//	      no_replies: true
+//	bundles:
+//	  friends:
+//	    users: [someuser, otheruser/media]
+//	    title: Friends
+//
+// Values that are present in the file override the corresponding flags.
+type config struct {
+	Addr          *string        `yaml:"addr"`
+	Base          *string        `yaml:"base"`
+	Instances     []string       `yaml:"instances"`
+	Format        *string        `yaml:"format"`
+	Rewrite       *bool          `yaml:"rewrite"`
+	RewriteTarget *string        `yaml:"rewrite_target"`
+	Retweets      *string        `yaml:"retweets"`
+	ImageSize     *string        `yaml:"image_size"`
+	Cycle         *bool          `yaml:"cycle"`
+	Timeout       *time.Duration `yaml:"timeout"`
+	HedgeDelay    *time.Duration `yaml:"hedge_delay"`
+	Deadline      *time.Duration `yaml:"deadline"`
+	DebugAuthors  *bool          `yaml:"debug_authors"`
+	MaxForeign    *float64       `yaml:"max_foreign"`
+	Quotes        *int           `yaml:"quotes"`
+	Threads       *bool          `yaml:"threads"`
+
+	Cache struct {
+		TTL   *time.Duration `yaml:"ttl"`
+		Stale *time.Duration `yaml:"stale"`
+		Size  *int           `yaml:"size"`
+		Dir   *string        `yaml:"dir"`
+	} `yaml:"cache"`
+
+	Circuit struct {
+		Failures *int           `yaml:"failures"`
+		Backoff  *time.Duration `yaml:"backoff"`
+	} `yaml:"circuit"`
+
+	Media struct {
+		Proxy   *bool `yaml:"proxy"`
+		MaxSize *int  `yaml:"max_size"` // megabytes
+	} `yaml:"media"`
+
+	Merge struct {
+		Enabled  *bool `yaml:"enabled"`
+		MaxItems *int  `yaml:"max_items"`
+		Parallel *int  `yaml:"parallel"`
+	} `yaml:"merge"`
+
+	// Feeds contains per-feed overrides keyed by user path, e.g. "someuser" or "someuser/media".
+	Feeds map[string]*feedConfig `yaml:"feeds"`
+
+	// Bundles contains named groups of feeds served together at /bundle/<name>.
+	Bundles map[string]*bundleConfig `yaml:"bundles"`
+}
+
+// feedConfig contains overrides for an individual feed.
+type feedConfig struct {
+	Format        string        `yaml:"format"`         // default format for the feed
+	Title         string        `yaml:"title"`          // replaces the feed's title
+	Rewrite       *bool         `yaml:"rewrite"`        // rewrite URLs in tweet content
+	RewriteTarget string        `yaml:"rewrite_target"` // where rewritten URLs point
+	Retweets      string        `yaml:"retweets"`       // "keep", "drop", or "annotate"
+	ImageSize     string        `yaml:"image_size"`     // "small", "medium", "large", or "orig"
+	Quotes        *int          `yaml:"quotes"`         // max quoted tweets to fetch
+	Threads       *bool         `yaml:"threads"`        // fold chains of self-replies
+	Filter        *filterConfig `yaml:"filter"`         // rules for dropping items
+
+	format    feedFormat     // parsed from Format
+	target    *rewriteTarget // parsed from RewriteTarget
+	retweets  retweetMode    // parsed from Retweets
+	imageSize imageSize      // parsed from ImageSize
+	filter    *itemFilter    // compiled from Filter
+}
+
+// init validates fc and initializes its unexported fields.
+func (fc *feedConfig) init() error {
+	var err error
+	if fc.Format != "" {
+		if fc.format, err = parseFeedFormat(fc.Format); err != nil {
+			return err
+		}
+	}
+	if fc.RewriteTarget != "" {
+		if fc.target, err = parseRewriteTarget(fc.RewriteTarget); err != nil {
+			return err
+		}
+	}
+	if fc.Retweets != "" {
+		if fc.retweets, err = parseRetweetMode(fc.Retweets); err != nil {
+			return err
+		}
+	}
+	if fc.ImageSize != "" {
+		if fc.imageSize, err = parseImageSize(fc.ImageSize); err != nil {
+			return err
+		}
+	}
+	if fc.Filter != nil {
+		if fc.filter, err = fc.Filter.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bundleConfig describes a group of feeds that are merged and served together.
+type bundleConfig struct {
+	Users      []string `yaml:"users"` // user paths, e.g. "someuser" or "someuser/media"
+	feedConfig `yaml:",inline"`
+}
+
+// readConfig reads and validates the YAML config file at p.
+func readConfig(p string) (*config, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cfg config
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Format != nil {
+		if _, err := parseFeedFormat(*cfg.Format); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RewriteTarget != nil {
+		if _, err := parseRewriteTarget(*cfg.RewriteTarget); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Retweets != nil {
+		if _, err := parseRetweetMode(*cfg.Retweets); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ImageSize != nil {
+		if _, err := parseImageSize(*cfg.ImageSize); err != nil {
+			return nil, err
+		}
+	}
+	feeds := make(map[string]*feedConfig, len(cfg.Feeds))
+	for user, fc := range cfg.Feeds {
+		if fc == nil {
+			fc = &feedConfig{}
+		}
+		if err := fc.init(); err != nil {
+			return nil, fmt.Errorf("feed %q: %v", user, err)
+		}
+		feeds[strings.ToLower(user)] = fc
+	}
+	cfg.Feeds = feeds
+
+	bundles := make(map[string]*bundleConfig, len(cfg.Bundles))
+	for name, bc := range cfg.Bundles {
+		if bc == nil || len(bc.Users) == 0 {
+			return nil, fmt.Errorf("bundle %q: no users", name)
+		}
+		if ms := bundleRegexp.FindStringSubmatch("bundle/" + name); ms == nil || ms[2] != "" {
+			return nil, fmt.Errorf("bundle %q: invalid name", name)
+		}
+		for _, u := range bc.Users {
+			if ms := userRegexp.FindStringSubmatch(u); ms == nil || ms[0] != u || ms[2] != "" ||
+				len(splitUsers(u)) == 0 {
+				return nil, fmt.Errorf("bundle %q: invalid user %q", name, u)
+			}
+		}
+		if err := bc.init(); err != nil {
+			return nil, fmt.Errorf("bundle %q: %v", name, err)
+		}
+		bundles[strings.ToLower(name)] = bc
+	}
+	cfg.Bundles = bundles
+
+	return &cfg, nil
+}
+
+// apply overrides the supplied values (initialized from flags) with ones from cfg.
+// addr is only used at startup and may be nil when reloading.
+func (cfg *config) apply(addr, base, instances *string, opts *handlerOptions) {
+	if cfg.Addr != nil && addr != nil {
+		*addr = *cfg.Addr
+	}
+	if cfg.Base != nil {
+		*base = *cfg.Base
+	}
+	if len(cfg.Instances) > 0 {
+		*instances = strings.Join(cfg.Instances, ",")
+	}
+	if cfg.Format != nil {
+		opts.format, _ = parseFeedFormat(*cfg.Format) // validated by readConfig
+	}
+	if cfg.Rewrite != nil {
+		opts.rewrite = *cfg.Rewrite
+	}
+	if cfg.RewriteTarget != nil {
+		opts.target, _ = parseRewriteTarget(*cfg.RewriteTarget) // validated by readConfig
+	}
+	if cfg.Retweets != nil {
+		opts.retweets, _ = parseRetweetMode(*cfg.Retweets) // validated by readConfig
+	}
+	if cfg.ImageSize != nil {
+		opts.imageSize, _ = parseImageSize(*cfg.ImageSize) // validated by readConfig
+	}
+	if cfg.Cycle != nil {
+		opts.cycle = *cfg.Cycle
+	}
+	if cfg.Timeout != nil {
+		opts.timeout = *cfg.Timeout
+	}
+	if cfg.Deadline != nil {
+		opts.deadline = *cfg.Deadline
+	}
+	if cfg.HedgeDelay != nil {
+		opts.hedgeDelay = *cfg.HedgeDelay
+	}
+	if cfg.DebugAuthors != nil {
+		opts.debugAuthors = *cfg.DebugAuthors
+	}
+	if cfg.MaxForeign != nil {
+		opts.maxForeign = *cfg.MaxForeign
+	}
+	if cfg.Quotes != nil {
+		opts.quotes = *cfg.Quotes
+	}
+	if cfg.Threads != nil {
+		opts.threads = *cfg.Threads
+	}
+	if cfg.Cache.TTL != nil {
+		opts.cacheTTL = *cfg.Cache.TTL
+	}
+	if cfg.Cache.Stale != nil {
+		opts.cacheStale = *cfg.Cache.Stale
+	}
+	if cfg.Cache.Size != nil {
+		opts.cacheSize = *cfg.Cache.Size
+	}
+	if cfg.Cache.Dir != nil {
+		opts.cacheDir = *cfg.Cache.Dir
+	}
+	if cfg.Circuit.Failures != nil {
+		opts.circuitFailures = *cfg.Circuit.Failures
+	}
+	if cfg.Circuit.Backoff != nil {
+		opts.circuitBackoff = *cfg.Circuit.Backoff
+	}
+	if cfg.Media.Proxy != nil {
+		opts.mediaProxy = *cfg.Media.Proxy
+	}
+	if cfg.Media.MaxSize != nil {
+		opts.mediaMaxSize = int64(*cfg.Media.MaxSize) << 20
+	}
+	if cfg.Merge.Enabled != nil {
+		opts.merge = *cfg.Merge.Enabled
+	}
+	if cfg.Merge.MaxItems != nil {
+		opts.mergeMax = *cfg.Merge.MaxItems
+	}
+	if cfg.Merge.Parallel != nil {
+		opts.mergeParallel = *cfg.Merge.Parallel
+	}
+	opts.feeds = cfg.Feeds
+	opts.bundles = cfg.Bundles
+}
+
+// reloadableHandler is an http.Handler that forwards requests to a handler
+// that can be replaced when the configuration is reloaded.
+type reloadableHandler struct {
+	mu       sync.RWMutex
+	hnd      *handler
+	draining bool           // drain has been called, so new requests are rejected
+	active   sync.WaitGroup // in-flight requests; only added to while holding mu if !draining
+}
+
+func (rh *reloadableHandler) get() *handler {
+	rh.mu.RLock()
+	defer rh.mu.RUnlock()
+	return rh.hnd
+}
+
+func (rh *reloadableHandler) set(hnd *handler) {
+	rh.mu.Lock()
+	rh.hnd = hnd
+	rh.mu.Unlock()
+}
+
+func (rh *reloadableHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// FastCGI connections can keep sending requests after the listener is closed,
+	// so check for draining before adding to active to avoid racing with drain's Wait.
+	rh.mu.RLock()
+	if rh.draining {
+		rh.mu.RUnlock()
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	rh.active.Add(1)
+	hnd := rh.hnd
+	rh.mu.RUnlock()
+
+	defer rh.active.Done()
+	hnd.ServeHTTP(w, req)
+}
+
+// drain rejects new requests and waits for in-flight requests and background refreshes
+// to finish. ctx's error is returned if it's canceled first.
+func (rh *reloadableHandler) drain(ctx context.Context) error {
+	rh.mu.Lock()
+	rh.draining = true
+	rh.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		rh.active.Wait()
+		rh.get().wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
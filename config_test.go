@@ -0,0 +1,160 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeConfig writes data to a config file in a new temp dir and returns the file's path.
+// The caller should remove the file's directory.
+func writeConfig(t *testing.T, data string) string {
+	dir, err := ioutil.TempDir("", "nitter-rss-proxy.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReadConfig(t *testing.T) {
+	p := writeConfig(t, `
+addr: localhost:9000
+instances:
+  - https://a.example.org
+  - https://b.example.org
+format: rss
+rewrite: false
+timeout: 5s
+cache:
+  ttl: 2m
+  dir: /tmp/cache
+circuit:
+  failures: 5
+feeds:
+  SomeUser:
+    format: json
+    title: Custom Title
+  other/media:
+    rewrite: true
+bundles:
+  Friends:
+    users: [someuser, other/media]
+    retweets: drop
+`)
+	defer os.RemoveAll(filepath.Dir(p))
+
+	cfg, err := readConfig(p)
+	if err != nil {
+		t.Fatal("readConfig failed: ", err)
+	}
+
+	addr, base, instances := "localhost:8080", "https://proxy.example.org", "https://nitter.net"
+	opts := handlerOptions{
+		cycle:           true,
+		timeout:         10 * time.Second,
+		format:          atomFormat,
+		rewrite:         true,
+		cacheTTL:        5 * time.Minute,
+		cacheStale:      time.Hour,
+		circuitFailures: 3,
+	}
+	cfg.apply(&addr, &base, &instances, &opts)
+
+	if want := "localhost:9000"; addr != want {
+		t.Errorf("addr = %q; want %q", addr, want)
+	}
+	if want := "https://proxy.example.org"; base != want {
+		t.Errorf("base = %q; want %q", base, want)
+	}
+	if want := "https://a.example.org,https://b.example.org"; instances != want {
+		t.Errorf("instances = %q; want %q", instances, want)
+	}
+	yes := true
+	want := handlerOptions{
+		cycle:           true,
+		timeout:         5 * time.Second,
+		format:          rssFormat,
+		rewrite:         false,
+		cacheTTL:        2 * time.Minute,
+		cacheStale:      time.Hour,
+		cacheDir:        "/tmp/cache",
+		circuitFailures: 5,
+		feeds: map[string]*feedConfig{
+			"someuser":    {Format: "json", Title: "Custom Title", format: jsonFormat},
+			"other/media": {Rewrite: &yes},
+		},
+		bundles: map[string]*bundleConfig{
+			"friends": {
+				Users:      []string{"someuser", "other/media"},
+				feedConfig: feedConfig{Retweets: "drop", retweets: retweetsDrop},
+			},
+		},
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("apply produced %+v; want %+v", opts, want)
+	}
+}
+
+func TestReadConfig_Invalid(t *testing.T) {
+	for _, data := range []string{
+		"format: bogus\n",
+		"feeds:\n  someuser:\n    format: bogus\n",
+		"retweets: bogus\n",
+		"rewrite_target: ftp://example.org\n",
+		"bundles:\n  empty:\n    title: Empty\n",
+		"bundles:\n  bad:\n    users: [someuser.json]\n",
+		"bundles:\n  bad:\n    users: [\",\"]\n",
+		"bundles:\n  bad name:\n    users: [someuser]\n",
+		"feeds:\n  someuser:\n    retweets: bogus\n",
+		"unknown_field: true\n",
+		"timeout: 10\n",
+	} {
+		p := writeConfig(t, data)
+		if _, err := readConfig(p); err == nil {
+			t.Errorf("readConfig unexpectedly succeeded for %q", data)
+		}
+		os.RemoveAll(filepath.Dir(p))
+	}
+}
+
+func TestReloadableHandler_Drain(t *testing.T) {
+	hnd, err := newHandler("", "https://nitter.example.org", handlerOptions{format: atomFormat})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+	rh := &reloadableHandler{hnd: hnd}
+
+	// Simulate an in-flight request.
+	rh.active.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rh.drain(ctx); err == nil {
+		t.Error("drain succeeded with in-flight request")
+	}
+
+	rh.active.Done()
+	if err := rh.drain(context.Background()); err != nil {
+		t.Error("drain failed without in-flight requests: ", err)
+	}
+
+	// New requests should be rejected after draining starts.
+	w := httptest.NewRecorder()
+	rh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/someuser", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Request after drain returned %v; want %v", w.Code, http.StatusServiceUnavailable)
+	}
+}
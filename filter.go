@@ -0,0 +1,152 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// replyTitlePrefix is used by Nitter at the start of replies' titles, e.g. "R to @someuser: ...".
+const replyTitlePrefix = "R to @"
+
+// filterConfig describes rules for dropping items from a feed.
+// It can be supplied via a feed's "filter" section in the config file or via
+// query parameters with the same names.
+type filterConfig struct {
+	Include    string `yaml:"include"`     // regexp that title or content must match
+	Exclude    string `yaml:"exclude"`     // regexp that title and content must not match
+	NoRetweets bool   `yaml:"no_retweets"` // drop retweets
+	NoReplies  bool   `yaml:"no_replies"`  // drop replies
+	MediaOnly  bool   `yaml:"media_only"`  // drop items without images or videos
+	MinLength  int    `yaml:"min_length"`  // drop items with fewer runes of text
+}
+
+// filterParams lists the query parameters used to create filters.
+var filterParams = []string{"include", "exclude", "no_retweets", "no_replies", "media_only", "min_length"}
+
+// queryFilterConfig returns a filterConfig from q's parameters.
+// nil is returned if q doesn't contain any filter parameters.
+func queryFilterConfig(q url.Values) (*filterConfig, error) {
+	var found bool
+	for _, p := range filterParams {
+		if _, ok := q[p]; ok {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	parseBool := func(name string) (bool, error) {
+		if _, ok := q[name]; !ok {
+			return false, nil
+		}
+		s := q.Get(name)
+		if s == "" {
+			return true, nil // treat "?no_replies" as "?no_replies=1"
+		}
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return false, fmt.Errorf("bad %v %q", name, s)
+		}
+		return v, nil
+	}
+
+	fc := filterConfig{Include: q.Get("include"), Exclude: q.Get("exclude")}
+	var err error
+	if fc.NoRetweets, err = parseBool("no_retweets"); err != nil {
+		return nil, err
+	}
+	if fc.NoReplies, err = parseBool("no_replies"); err != nil {
+		return nil, err
+	}
+	if fc.MediaOnly, err = parseBool("media_only"); err != nil {
+		return nil, err
+	}
+	if s := q.Get("min_length"); s != "" {
+		if fc.MinLength, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("bad min_length %q", s)
+		}
+	}
+	return &fc, nil
+}
+
+// itemFilter is a compiled filterConfig.
+type itemFilter struct {
+	include, exclude *regexp.Regexp
+	noRetweets       bool
+	noReplies        bool
+	mediaOnly        bool
+	minLength        int
+}
+
+// compile validates fc and returns a corresponding itemFilter.
+func (fc *filterConfig) compile() (*itemFilter, error) {
+	f := &itemFilter{
+		noRetweets: fc.NoRetweets,
+		noReplies:  fc.NoReplies,
+		mediaOnly:  fc.MediaOnly,
+		minLength:  fc.MinLength,
+	}
+	var err error
+	if fc.Include != "" {
+		if f.include, err = regexp.Compile(fc.Include); err != nil {
+			return nil, fmt.Errorf("bad include regexp: %v", err)
+		}
+	}
+	if fc.Exclude != "" {
+		if f.exclude, err = regexp.Compile(fc.Exclude); err != nil {
+			return nil, fmt.Errorf("bad exclude regexp: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// mediaRegexp matches HTML tags used by Nitter to embed media in tweets.
+var mediaRegexp = regexp.MustCompile(`(?i)<(img|video|source)\b`)
+
+// keep returns true if oi should be included in a feed containing tweets from users
+// (as returned by feedUsers). oi's Description field should contain the tweet's original
+// (i.e. unrewritten) HTML. marked is passed to isRetweet.
+func (f *itemFilter) keep(oi *gofeed.Item, users []string, marked bool) bool {
+	if f.noRetweets && isRetweet(oi, users, marked) {
+		return false
+	}
+	if f.noReplies && strings.HasPrefix(oi.Title, replyTitlePrefix) {
+		return false
+	}
+	if f.mediaOnly && !mediaRegexp.MatchString(oi.Description) {
+		return false
+	}
+	if f.minLength > 0 && len([]rune(tweetText(oi.Title))) < f.minLength {
+		return false
+	}
+	if f.include != nil && !f.include.MatchString(oi.Title) && !f.include.MatchString(oi.Description) {
+		return false
+	}
+	if f.exclude != nil && (f.exclude.MatchString(oi.Title) || f.exclude.MatchString(oi.Description)) {
+		return false
+	}
+	return true
+}
+
+// tweetText returns the text of a tweet from the supplied Nitter item title,
+// removing any "RT by @user: " or "R to @user: " prefix.
+func tweetText(title string) string {
+	for _, pre := range []string{retweetTitlePrefix, replyTitlePrefix} {
+		if strings.HasPrefix(title, pre) {
+			if i := strings.Index(title, ": "); i >= 0 {
+				return title[i+2:]
+			}
+		}
+	}
+	return title
+}
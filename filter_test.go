@@ -0,0 +1,84 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestItemFilter(t *testing.T) {
+	var (
+		plain   = &gofeed.Item{Title: "Just a regular tweet", Description: "<p>Just a regular tweet</p>"}
+		short   = &gofeed.Item{Title: "Hi", Description: "<p>Hi</p>"}
+		retweet = &gofeed.Item{Title: "RT by @someuser: Something else", Description: "<p>Something else</p>"}
+		reply   = &gofeed.Item{Title: "R to @other: Sure", Description: "<p>Sure</p>"}
+		media   = &gofeed.Item{Title: "Look", Description: `<p>Look</p><img src="https://example.org/pic/a.jpg" />`}
+	)
+	all := []*gofeed.Item{plain, short, retweet, reply, media}
+
+	for _, tc := range []struct {
+		query string
+		want  []*gofeed.Item
+	}{
+		{"no_retweets=1", []*gofeed.Item{plain, short, reply, media}},
+		{"no_replies", []*gofeed.Item{plain, short, retweet, media}},
+		{"no_replies=false", all},
+		{"media_only=true", []*gofeed.Item{media}},
+		{"min_length=5", []*gofeed.Item{plain, retweet}},
+		{"include=(?i)REGULAR|look", []*gofeed.Item{plain, media}},
+		{"include=pic/", []*gofeed.Item{media}}, // content is also checked
+		{"exclude=Hi|Sure", []*gofeed.Item{plain, retweet, media}},
+		{"no_retweets=1&no_replies=1&min_length=3", []*gofeed.Item{plain, media}},
+	} {
+		q, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fc, err := queryFilterConfig(q)
+		if err != nil || fc == nil {
+			t.Errorf("queryFilterConfig(%q) = %v, %v", tc.query, fc, err)
+			continue
+		}
+		f, err := fc.compile()
+		if err != nil {
+			t.Errorf("Compiling %q failed: %v", tc.query, err)
+			continue
+		}
+		var got []*gofeed.Item
+		for _, oi := range all {
+			if f.keep(oi, []string{"someuser"}, marksRetweets(all)) {
+				got = append(got, oi)
+			}
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%q kept %v item(s); want %v", tc.query, len(got), len(tc.want))
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%q kept item %d %q; want %q", tc.query, i, got[i].Title, tc.want[i].Title)
+			}
+		}
+	}
+}
+
+func TestQueryFilterConfig_Invalid(t *testing.T) {
+	for _, query := range []string{"no_retweets=maybe", "min_length=abc", "include=("} {
+		q, _ := url.ParseQuery(query)
+		fc, err := queryFilterConfig(q)
+		if err == nil {
+			_, err = fc.compile()
+		}
+		if err == nil {
+			t.Errorf("%q was unexpectedly accepted", query)
+		}
+	}
+
+	if fc, err := queryFilterConfig(url.Values{"max_position": {"123"}}); fc != nil || err != nil {
+		t.Errorf("queryFilterConfig without filter params = %v, %v; want nil, nil", fc, err)
+	}
+}
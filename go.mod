@@ -6,5 +6,6 @@ require (
 	github.com/gorilla/feeds v1.1.1
 	github.com/kr/pretty v0.3.0 // indirect
 	github.com/mmcdole/gofeed v1.1.1
-	golang.org/x/net v0.0.0-20220909164309-bea034e7d591 // indirect
+	golang.org/x/net v0.0.0-20220909164309-bea034e7d591
+	gopkg.in/yaml.v3 v3.0.1
 )
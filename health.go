@@ -0,0 +1,274 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"errors"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	latencyWeight     = 0.3             // weight of newest sample in latency EWMA
+	latencyBucket     = 2 * time.Second // latencies below this are treated as equivalent
+	failureHalfLife   = time.Minute     // time after which failures count half as much
+	maxCircuitBackoff = time.Hour       // max time for which an instance's circuit stays open
+)
+
+// instanceHealth describes the recent health of a Nitter instance.
+type instanceHealth struct {
+	failures    int           // consecutive failures
+	lastErr     string        // most recent error
+	lastStatus  int           // HTTP status code from most recent fetch (0 if unknown)
+	lastSuccess time.Time     // time of most recent successful fetch
+	lastFailure time.Time     // time of most recent failed fetch
+	latency     time.Duration // EWMA of successful fetch latency
+	backoff     time.Duration // time for which circuit was most recently opened
+	openUntil   time.Time     // circuit is open until this time
+	probing     bool          // half-open circuit is being probed
+}
+
+// circuitState describes an instance's circuit breaker.
+// Values are ordered by preference.
+type circuitState int
+
+const (
+	circuitClosed   circuitState = iota // instance is healthy or hasn't failed enough to be skipped
+	circuitHalfOpen                     // backoff has elapsed and instance can be probed
+	circuitOpen                         // instance is skipped
+)
+
+func (cs circuitState) String() string {
+	switch cs {
+	case circuitClosed:
+		return "closed"
+	case circuitHalfOpen:
+		return "half-open"
+	case circuitOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// recentFailures returns ih's consecutive failures, halved for each failureHalfLife
+// that has elapsed since the most recent one.
+func (ih *instanceHealth) recentFailures(now time.Time) int {
+	if ih.failures == 0 {
+		return 0
+	}
+	halvings := now.Sub(ih.lastFailure) / failureHalfLife
+	if halvings >= 31 {
+		return 0
+	}
+	return ih.failures >> uint(halvings)
+}
+
+// latencyClass returns a coarse classification of ih's latency, where 0 is fastest.
+// Each class beyond 0 covers latencies twice as long as the previous one.
+// Instances without successful fetches are in class 0.
+func (ih *instanceHealth) latencyClass() int {
+	return bits.Len64(uint64(ih.latency / latencyBucket))
+}
+
+func (ih *instanceHealth) state(now time.Time) circuitState {
+	switch {
+	case ih.openUntil.IsZero():
+		return circuitClosed
+	case now.Before(ih.openUntil) || ih.probing:
+		return circuitOpen
+	default:
+		return circuitHalfOpen
+	}
+}
+
+// healthTracker tracks the health of Nitter instances and implements a circuit breaker
+// so that failing instances are skipped for a while.
+// It's safe for concurrent use.
+type healthTracker struct {
+	mu        sync.Mutex
+	threshold int                        // consecutive failures needed to open circuit
+	backoff   time.Duration              // initial time for which circuit is opened
+	insts     map[string]*instanceHealth // keyed by instance URL
+}
+
+func newHealthTracker(threshold int, backoff time.Duration) *healthTracker {
+	return &healthTracker{
+		threshold: threshold,
+		backoff:   backoff,
+		insts:     make(map[string]*instanceHealth),
+	}
+}
+
+// setLimits updates ht's circuit breaker settings, e.g. after the configuration has been reloaded.
+func (ht *healthTracker) setLimits(threshold int, backoff time.Duration) {
+	ht.mu.Lock()
+	ht.threshold, ht.backoff = threshold, backoff
+	ht.mu.Unlock()
+}
+
+// get returns the health of the instance at u, creating it if needed.
+// ht.mu must be held.
+func (ht *healthTracker) get(u *url.URL) *instanceHealth {
+	key := u.String()
+	ih := ht.insts[key]
+	if ih == nil {
+		ih = &instanceHealth{}
+		ht.insts[key] = ih
+	}
+	return ih
+}
+
+// order returns the instances that should be tried, in order of preference.
+// Instances are first ordered starting at index start, then stably sorted by circuit state,
+// recent failures, and latency class, so that start still cycles among similarly-healthy instances.
+// Instances with open circuits are omitted unless no other instances are available.
+func (ht *healthTracker) order(instances []*url.URL, start int, now time.Time) []*url.URL {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	type cand struct {
+		u        *url.URL
+		state    circuitState
+		failures int
+		latency  int
+	}
+	cands := make([]cand, len(instances))
+	for i := range instances {
+		u := instances[(start+i)%len(instances)]
+		ih := ht.get(u)
+		cands[i] = cand{u, ih.state(now), ih.recentFailures(now), ih.latencyClass()}
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		if cands[i].state != cands[j].state {
+			return cands[i].state < cands[j].state
+		}
+		if cands[i].failures != cands[j].failures {
+			return cands[i].failures < cands[j].failures
+		}
+		return cands[i].latency < cands[j].latency
+	})
+
+	ordered := make([]*url.URL, 0, len(cands))
+	for _, c := range cands {
+		if c.state != circuitOpen {
+			ordered = append(ordered, c.u)
+		}
+	}
+	// If every circuit is open, try everything rather than failing immediately.
+	if len(ordered) == 0 {
+		for _, c := range cands {
+			ordered = append(ordered, c.u)
+		}
+	}
+	return ordered
+}
+
+// begin should be called before fetching from u.
+func (ht *healthTracker) begin(u *url.URL, now time.Time) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	if ih := ht.get(u); ih.state(now) == circuitHalfOpen {
+		ih.probing = true
+	}
+}
+
+// cancel records that a fetch from u was canceled before completing, e.g. because
+// another instance responded first. u's health is otherwise unchanged.
+func (ht *healthTracker) cancel(u *url.URL) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	ht.get(u).probing = false
+}
+
+// success records a successful fetch from u that took the supplied time.
+func (ht *healthTracker) success(u *url.URL, latency time.Duration, now time.Time) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	ih := ht.get(u)
+	ih.lastSuccess = now
+	ih.lastStatus = http.StatusOK
+	ih.failures = 0
+	ih.backoff = 0
+	ih.openUntil = time.Time{}
+	ih.probing = false
+	if ih.latency == 0 {
+		ih.latency = latency
+	} else {
+		ih.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(ih.latency))
+	}
+}
+
+// failure records a failed fetch from u.
+// The instance's circuit is opened (with exponential backoff) if it has failed too many
+// times in a row or if it was being probed.
+func (ht *healthTracker) failure(u *url.URL, err error, now time.Time) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	ih := ht.get(u)
+	ih.failures++
+	ih.lastErr = err.Error()
+	ih.lastFailure = now
+	ih.lastStatus = 0
+	var se *statusError
+	if errors.As(err, &se) {
+		ih.lastStatus = se.code
+	}
+	if ih.probing || ih.failures >= ht.threshold {
+		if ih.backoff *= 2; ih.backoff == 0 {
+			ih.backoff = ht.backoff
+		} else if ih.backoff > maxCircuitBackoff {
+			ih.backoff = maxCircuitBackoff
+		}
+		ih.openUntil = now.Add(ih.backoff)
+		ih.probing = false
+	}
+}
+
+// instanceStatus describes an instance's health for the status page.
+type instanceStatus struct {
+	URL         string     `json:"url"`
+	State       string     `json:"state"`
+	Failures    int        `json:"consecutiveFailures"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastStatus  int        `json:"lastStatus,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+	LatencyMs   int64      `json:"avgLatencyMs"`
+}
+
+// status returns the current health of each of the supplied instances.
+func (ht *healthTracker) status(instances []*url.URL, now time.Time) []instanceStatus {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	optTime := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+
+	sts := make([]instanceStatus, len(instances))
+	for i, u := range instances {
+		ih := ht.get(u)
+		sts[i] = instanceStatus{
+			URL:         u.String(),
+			State:       ih.state(now).String(),
+			Failures:    ih.failures,
+			LastError:   ih.lastErr,
+			LastStatus:  ih.lastStatus,
+			LastSuccess: optTime(ih.lastSuccess),
+			LastFailure: optTime(ih.lastFailure),
+			LatencyMs:   ih.latency.Milliseconds(),
+		}
+	}
+	return sts
+}
@@ -0,0 +1,80 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHealthTracker(t *testing.T) {
+	var instances []*url.URL
+	for _, s := range []string{"https://a.example.org", "https://b.example.org", "https://c.example.org"} {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances = append(instances, u)
+	}
+	a, b, c := instances[0], instances[1], instances[2]
+
+	const backoff = time.Minute
+	ht := newHealthTracker(2, backoff)
+	now := time.Unix(1000, 0)
+	check := func(desc string, start int, want ...*url.URL) {
+		t.Helper()
+		if got := ht.order(instances, start, now); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: order(..., %d) = %v; want %v", desc, start, got, want)
+		}
+	}
+
+	check("initial", 0, a, b, c)
+	check("initial", 1, b, c, a)
+
+	// A single failure should just move the instance behind healthy ones.
+	err := errors.New("failed")
+	ht.failure(a, err, now)
+	check("after first failure", 0, b, c, a)
+
+	// The failure should stop counting against the instance after a while.
+	now = now.Add(failureHalfLife)
+	check("after failure decayed", 0, a, b, c)
+	check("after failure decayed", 1, b, c, a)
+
+	// After the second failure, the instance's circuit should be opened.
+	ht.failure(a, err, now)
+	check("after second failure", 0, b, c)
+
+	// Once the backoff has elapsed, the instance should be probed.
+	now = now.Add(backoff)
+	check("after backoff", 0, b, c, a)
+	ht.begin(a, now)
+	check("while probing", 0, b, c)
+
+	// A failed probe should double the backoff.
+	ht.failure(a, err, now)
+	now = now.Add(backoff)
+	check("after failed probe", 0, b, c)
+	now = now.Add(backoff)
+	check("after doubled backoff", 0, b, c, a)
+
+	// A successful probe should close the circuit.
+	ht.begin(a, now)
+	ht.success(a, time.Second, now)
+	check("after successful probe", 0, a, b, c)
+
+	// Slow instances should be tried after faster ones.
+	ht.success(b, 5*latencyBucket, now)
+	check("after slow fetch", 1, c, a, b)
+
+	// If all circuits are open, all instances should still be returned.
+	for _, u := range instances {
+		ht.failure(u, err, now)
+		ht.failure(u, err, now)
+	}
+	check("all open", 1, c, a, b)
+}
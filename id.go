@@ -0,0 +1,42 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"regexp"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// canonicalIDPrefix is prepended to numeric tweet IDs to produce items' IDs.
+const canonicalIDPrefix = "https://twitter.com/i/status/"
+
+// tweetIDRegexp matches a numeric tweet ID in a status URL (optionally followed by a path,
+// query, or fragment) or on its own.
+var tweetIDRegexp = regexp.MustCompile(`(?:^|/status(?:es)?/)(\d+)(?:[/?#]|$)`)
+
+// tweetID returns the numeric tweet ID from s, e.g. "123" for
+// "https://nitter.example.org/someuser/status/123#m".
+// An empty string is returned if s doesn't contain a tweet ID.
+func tweetID(s string) string {
+	if ms := tweetIDRegexp.FindStringSubmatch(s); ms != nil {
+		return ms[1]
+	}
+	return ""
+}
+
+// itemID returns a stable ID for oi, e.g. "https://twitter.com/i/status/123".
+// The ID doesn't depend on the instance that served the feed, the format of its GUIDs,
+// or how URLs are rewritten, so readers don't see duplicate items after cycling instances.
+func itemID(oi *gofeed.Item) string {
+	for _, s := range []string{oi.GUID, oi.Link} {
+		if id := tweetID(s); id != "" {
+			return canonicalIDPrefix + id
+		}
+	}
+	if oi.GUID != "" {
+		return rewriteTwitterURL(oi.GUID)
+	}
+	return rewriteTwitterURL(oi.Link)
+}
@@ -0,0 +1,40 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestItemID(t *testing.T) {
+	const want = canonicalIDPrefix + "1234567890"
+	for _, tc := range []struct {
+		guid, link, want string
+	}{
+		// GUIDs seen from various Nitter versions and instances.
+		{"https://nitter.net/someuser/status/1234567890#m", "", want},
+		{"http://nitter.example.org/someuser/status/1234567890", "", want},
+		{"http://localhost/SomeUser/status/1234567890#m", "", want},
+		{"https://nitter.example.org/nitter/someuser/status/1234567890", "", want},
+		{"https://nitter.net/i/web/status/1234567890", "", want},
+		{"https://nitter.net/someuser/statuses/1234567890", "", want},
+		{"https://nitter.net/someuser/status/1234567890/photo/1", "", want},
+		{"https://twitter.com/someuser/status/1234567890", "", want},
+		{"/someuser/status/1234567890", "", want},
+		{"1234567890", "", want},
+		// The link is used if the GUID doesn't contain an ID.
+		{"", "https://nitter.net/someuser/status/1234567890#m", want},
+		{"abc", "https://nitter.net/someuser/status/1234567890#m", want},
+		// Other URLs are just rewritten.
+		{"https://nitter.net/someuser/status/12ab", "", "https://twitter.com/someuser/status/12ab"},
+		{"https://nitter.net/someuser#m", "", "https://twitter.com/someuser"},
+	} {
+		oi := &gofeed.Item{GUID: tc.guid, Link: tc.link}
+		if got := itemID(oi); got != tc.want {
+			t.Errorf("itemID(%q, %q) = %q; want %q", tc.guid, tc.link, got, tc.want)
+		}
+	}
+}
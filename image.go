@@ -0,0 +1,104 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// imageSize is a size in which pbs.twimg.com serves images, passed via the "name" parameter.
+type imageSize string
+
+const (
+	imageSizeDefault imageSize = ""       // leave images' URLs unchanged
+	imageSizeSmall   imageSize = "small"  // fits within 680x680
+	imageSizeMedium  imageSize = "medium" // fits within 1200x1200
+	imageSizeLarge   imageSize = "large"  // fits within 2048x2048
+	imageSizeOrig    imageSize = "orig"   // original resolution
+)
+
+// srcsetSizes lists the image sizes included in srcset attributes, along with the max
+// dimensions of their bounding boxes. Images are scaled to fit within these boxes, so
+// the ratio between two sizes' dimensions gives their relative pixel density.
+var srcsetSizes = []struct {
+	size imageSize
+	dim  int
+}{
+	{imageSizeSmall, 680},
+	{imageSizeMedium, 1200},
+	{imageSizeLarge, 2048},
+}
+
+// parseImageSize returns the imageSize named by s.
+func parseImageSize(s string) (imageSize, error) {
+	switch sz := imageSize(strings.ToLower(s)); sz {
+	case imageSizeDefault, imageSizeSmall, imageSizeMedium, imageSizeLarge, imageSizeOrig:
+		return sz, nil
+	default:
+		return "", fmt.Errorf("unknown image size %q", s)
+	}
+}
+
+// sizedImageURL returns a copy of u, a pbs.twimg.com media URL, requesting size.
+func sizedImageURL(u *url.URL, size imageSize) string {
+	su := *u
+	q := su.Query()
+	q.Set("name", string(size))
+	su.RawQuery = q.Encode()
+	return su.String()
+}
+
+// resizeImage updates the attributes of an <img> tag whose original (i.e. unrewritten) src
+// attribute was src to request size from pbs.twimg.com, and adds a srcset attribute listing
+// larger sizes with density descriptors relative to size (e.g. "1.76x" for medium when size
+// is small). The densities are approximate for images smaller than the larger boxes, since
+// pbs.twimg.com doesn't upscale. The returned link is the URL of the original-resolution
+// image, or an empty string if the image isn't served by pbs.twimg.com.
+func (ur *urlRewriter) resizeImage(attrs []html.Attribute, src string, size imageSize) (
+	[]html.Attribute, string) {
+	u, err := url.Parse(ur.canonicalURL(src))
+	if err != nil || u.Host != "pbs.twimg.com" || !strings.HasPrefix(u.Path, "/media/") {
+		return attrs, ""
+	}
+
+	// Width descriptors can't be used since only the bounding boxes' dimensions are known.
+	// The requested size is implicitly 1x.
+	srcURL := ur.target.mapURL(sizedImageURL(u, size))
+	var cands []string
+	var dim int // size's bounding box dimension, set once it's reached in srcsetSizes
+	seen := map[string]bool{srcURL: true}
+	for _, s := range srcsetSizes {
+		if s.size == size {
+			dim = s.dim
+			continue
+		}
+		if dim == 0 {
+			continue // only list larger sizes
+		}
+		if su := ur.target.mapURL(sizedImageURL(u, s.size)); !seen[su] {
+			cands = append(cands, fmt.Sprintf("%s %.3gx", su, float64(s.dim)/float64(dim)))
+			seen[su] = true
+		}
+	}
+
+	var out []html.Attribute
+	for _, a := range attrs {
+		if a.Namespace == "" && a.Key == "srcset" {
+			continue // replaced below
+		}
+		if a.Namespace == "" && a.Key == "src" {
+			a.Val = srcURL
+		}
+		out = append(out, a)
+	}
+	// Only add srcset if the target actually serves different sizes.
+	if len(cands) > 0 {
+		out = append(out, html.Attribute{Key: "srcset", Val: strings.Join(cands, ", ")})
+	}
+	return out, ur.target.mapURL(sizedImageURL(u, imageSizeOrig))
+}
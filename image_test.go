@@ -0,0 +1,55 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRewriteContent_ImageSize(t *testing.T) {
+	loc, _ := url.Parse("https://nitter.net/user/rss")
+	nitter, _ := parseRewriteTarget("https://nitter.example.org")
+	const (
+		img = `<img src="https://nitter.net/pic/media%2FAbC.jpg" style="max-width:250px;" />`
+		pbs = `https://pbs.twimg.com/media/AbC?format=jpg&amp;name=`
+	)
+	for _, tc := range []struct {
+		orig   string
+		target *rewriteTarget
+		size   imageSize
+		want   string
+	}{
+		{img, nil, imageSizeDefault,
+			`<img src="https://pbs.twimg.com/media/AbC?format=jpg" style="max-width:250px;" />`},
+		{img, nil, imageSizeLarge,
+			`<a href="` + pbs + `orig"><img src="` + pbs + `large" style="max-width:250px;" /></a>`},
+		{img, nil, imageSizeMedium,
+			`<a href="` + pbs + `orig"><img src="` + pbs + `medium" style="max-width:250px;"` +
+				` srcset="` + pbs + `large 1.71x" /></a>`},
+		{`<a href="/user/status/1"><img src="/pic/media%2FAbC.jpg"></a>`, nil, imageSizeSmall,
+			`<a href="https://twitter.com/user/status/1"><img src="` + pbs + `small"` +
+				` srcset="` + pbs + `medium 1.76x, ` + pbs + `large 3.01x"></a>`},
+		// Nitter doesn't serve different sizes, but it does serve originals.
+		{img, nitter, imageSizeMedium,
+			`<a href="https://nitter.example.org/pic/orig/media%2FAbC.jpg">` +
+				`<img src="https://nitter.example.org/pic/media%2FAbC.jpg" style="max-width:250px;" /></a>`},
+		{`<img src="https://example.org/foo.jpg">`, nil, imageSizeLarge, `<img src="https://example.org/foo.jpg">`},
+	} {
+		if got, err := rewriteContent(tc.orig, loc, tc.target, tc.size); err != nil {
+			t.Errorf("rewriteContent(%q, %q) failed: %v", tc.orig, tc.size, err)
+		} else if got != tc.want {
+			t.Errorf("rewriteContent(%q, %q) = %q; want %q", tc.orig, tc.size, got, tc.want)
+		}
+	}
+
+	for _, s := range []string{"", "small", "Large", "orig"} {
+		if _, err := parseImageSize(s); err != nil {
+			t.Errorf("parseImageSize(%q) failed: %v", s, err)
+		}
+	}
+	if _, err := parseImageSize("huge"); err == nil {
+		t.Error(`parseImageSize("huge") unexpectedly succeeded`)
+	}
+}
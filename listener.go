@@ -0,0 +1,87 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	unixAddrPrefix = "unix:" // prefix for -addr values specifying Unix socket paths
+	listenFDsStart = 3       // first file descriptor passed by systemd socket activation
+)
+
+// listen returns a listener for addr, which is either a TCP address like "localhost:8080"
+// or a Unix socket path like "unix:/run/nitter-rss-proxy.sock". Unix sockets are created
+// with the supplied permissions. If the process was started via systemd socket activation,
+// the passed socket is used instead.
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		return ln, err
+	}
+	if strings.HasPrefix(addr, unixAddrPrefix) {
+		return listenUnix(addr[len(unixAddrPrefix):], mode)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenUnix listens on a Unix socket at p with the supplied permissions.
+// A stale socket left at p by a previous process is removed first.
+func listenUnix(p string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(p); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(p); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", p)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(p, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// systemdFDs returns the number of sockets passed to this process via systemd socket
+// activation, as described in sd_listen_fds(3).
+func systemdFDs() int {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return 0
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// systemdListener returns a listener for the socket passed via systemd socket activation.
+// nil is returned if the process wasn't socket-activated.
+func systemdListener() (net.Listener, error) {
+	n := systemdFDs()
+	if n == 0 {
+		return nil, nil
+	}
+	// Don't pass the sockets to child processes.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n > 1 {
+		return nil, errors.New("multiple sockets passed by systemd")
+	}
+	f := os.NewFile(listenFDsStart, "LISTEN_FD_"+strconv.Itoa(listenFDsStart))
+	defer f.Close() // FileListener dups the descriptor
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket: %v", err)
+	}
+	return ln, nil
+}
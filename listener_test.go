@@ -0,0 +1,67 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListen_Unix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nitter-rss-proxy.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "sock")
+	for i := 0; i < 2; i++ {
+		ln, err := listen(unixAddrPrefix+p, 0600)
+		if err != nil {
+			t.Fatal("listen failed: ", err)
+		}
+		if fi, err := os.Stat(p); err != nil {
+			t.Error(err)
+		} else if fi.Mode().Perm() != 0600 {
+			t.Errorf("Socket has mode %v; want %v", fi.Mode().Perm(), os.FileMode(0600))
+		}
+		conn, err := net.Dial("unix", p)
+		if err != nil {
+			t.Error("Failed connecting to socket: ", err)
+		} else {
+			conn.Close()
+		}
+		if i == 0 {
+			// Leave a stale socket behind to check that it's replaced.
+			ln.(*net.UnixListener).SetUnlinkOnClose(false)
+		}
+		ln.Close()
+	}
+}
+
+func TestSystemdFDs(t *testing.T) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+
+	for _, tc := range []struct {
+		pid, fds string
+		want     int
+	}{
+		{"", "", 0},
+		{strconv.Itoa(os.Getpid()), "1", 1},
+		{strconv.Itoa(os.Getpid() + 1), "1", 0}, // meant for another process
+		{strconv.Itoa(os.Getpid()), "bogus", 0},
+	} {
+		os.Setenv("LISTEN_PID", tc.pid)
+		os.Setenv("LISTEN_FDS", tc.fds)
+		if got := systemdFDs(); got != tc.want {
+			t.Errorf("systemdFDs() with LISTEN_PID=%q and LISTEN_FDS=%q = %v; want %v",
+				tc.pid, tc.fds, got, tc.want)
+		}
+	}
+}
@@ -4,7 +4,10 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -12,18 +15,24 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/fcgi"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/feeds"
 	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 const (
@@ -40,46 +49,229 @@ const (
 	rssFormat  feedFormat = "rss"
 )
 
+// parseFeedFormat returns the feedFormat named by s.
+func parseFeedFormat(s string) (feedFormat, error) {
+	switch f := feedFormat(strings.ToLower(s)); f {
+	case atomFormat, jsonFormat, rssFormat:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q", s)
+	}
+}
+
+// mediaTypeFormats maps from media types in Accept headers to feed formats.
+var mediaTypeFormats = map[string]feedFormat{
+	"application/atom+xml":  atomFormat,
+	"application/feed+json": jsonFormat,
+	"application/json":      jsonFormat,
+	"application/rss+xml":   rssFormat,
+}
+
+// acceptFormat returns the feed format preferred by the supplied Accept header value.
+// If def is among the formats with the highest quality, it's returned. Otherwise, ties
+// are resolved in favor of the first-listed format. An empty string is returned if none
+// of the listed media types correspond to a format.
+func acceptFormat(accept string, def feedFormat) feedFormat {
+	var best feedFormat
+	var bestQ float64
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		f, ok := mediaTypeFormats[strings.ToLower(strings.TrimSpace(fields[0]))]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > bestQ || (q == bestQ && f == def) {
+			best, bestQ = f, q
+		}
+	}
+	return best
+}
+
 func main() {
-	var opts handlerOptions
-
-	addr := flag.String("addr", "localhost:8080", "Network address to listen on")
-	base := flag.String("base", "", "Base URL for served feeds")
-	flag.BoolVar(&opts.cycle, "cycle", true, "Cycle through instances")
-	flag.BoolVar(&opts.debugAuthors, "debug-authors", true, "Log per-author tweet counts")
-	fastCGI := flag.Bool("fastcgi", false, "Use FastCGI instead of listening on -addr")
-	format := flag.String("format", "atom", `Feed format to write ("atom", "json", "rss")`)
-	instances := flag.String("instances", "https://nitter.net", "Comma-separated list of URLs of Nitter instances to use")
-	flag.BoolVar(&opts.rewrite, "rewrite", true, "Rewrite tweet content to point at twitter.com")
+	var flagOpts handlerOptions
+
+	addr := flag.String("addr", "localhost:8080", `Network address to listen on (e.g. "localhost:8080" or "unix:/path/to/sock")`)
+	flagBase := flag.String("base", "", "Base URL for served feeds")
+	cacheDir := flag.String("cache-dir", "", "Directory for persisting cached feeds across restarts")
+	cacheSize := flag.Int("cache-size", 1000, "Maximum number of feeds to cache")
+	cacheStale := flag.Int("cache-stale", 3600, "Seconds past -cache-ttl that cached feeds are served while refreshing")
+	cacheTTL := flag.Int("cache-ttl", 300, "Seconds to cache feeds fetched from Nitter instances (0 to disable)")
+	circuitBackoff := flag.Int("circuit-backoff", 60, "Initial seconds to skip instances after repeated failures")
+	flag.IntVar(&flagOpts.circuitFailures, "circuit-failures", 3, "Consecutive failures before skipping an instance")
+	configPath := flag.String("config", "", "YAML config file overriding flags (reloaded on SIGHUP)")
+	flag.BoolVar(&flagOpts.cycle, "cycle", true, "Cycle through instances")
+	deadline := flag.Int("deadline", 0, "Overall seconds to spend getting a requested feed (0 for no limit)")
+	flag.BoolVar(&flagOpts.debugAuthors, "debug-authors", true, "Log per-author tweet counts")
+	drainTimeout := flag.Int("drain-timeout", 5, "Seconds to wait for in-flight requests when shutting down")
+	fastCGI := flag.Bool("fastcgi", false, "Use FastCGI instead of HTTP (on stdin unless -addr is a Unix socket)")
+	format := flag.String("format", "atom", `Default feed format to write ("atom", "json", "rss")`)
+	hedgeDelay := flag.Int("hedge-delay", 0, "Seconds to wait for an instance before also trying the next one (0 to disable)")
+	imageSize := flag.String("image-size", "",
+		`Size of tweets' images ("small", "medium", "large", "orig"), with srcset and links to originals`)
+	flagInstances := flag.String("instances", "https://nitter.net", "Comma-separated list of URLs of Nitter instances to use")
+	flag.Float64Var(&flagOpts.maxForeign, "max-foreign", 0.5,
+		"Fraction of items from other users above which fetched feeds are rejected (0 to disable)")
+	mediaMaxSize := flag.Int("media-max-size", 50, "Maximum size in megabytes of media served by -media-proxy (0 for no limit)")
+	flag.BoolVar(&flagOpts.mediaProxy, "media-proxy", false, "Serve tweets' media via the proxy's /media/ endpoint (requires -base)")
+	flag.BoolVar(&flagOpts.merge, "merge", true, "Fetch comma-separated users individually and merge their feeds")
+	flag.IntVar(&flagOpts.mergeMax, "merge-max", 100, "Maximum number of items in merged feeds (0 for no limit)")
+	flag.IntVar(&flagOpts.mergeParallel, "merge-parallel", 4,
+		"Maximum number of users' feeds to fetch at once for merged feeds (0 for no limit)")
+	flag.IntVar(&flagOpts.quotes, "quotes", 0, "Maximum quoted tweets to fetch and inline per feed request (0 to disable)")
+	retweets := flag.String("retweets", "keep", `How to handle retweets ("keep", "drop", "annotate")`)
+	flag.BoolVar(&flagOpts.rewrite, "rewrite", true, "Rewrite URLs in tweet content to point at -rewrite-target")
+	rewriteTarget := flag.String("rewrite-target", "twitter.com",
+		`Where rewritten URLs point ("twitter.com", "x.com", "original", or a Nitter instance's URL)`)
+	socketMode := flag.String("socket-mode", "0660", "Octal permissions for Unix socket specified by -addr")
+	flag.BoolVar(&flagOpts.threads, "threads", false, "Fold chains of self-replies into single items")
 	timeout := flag.Int("timeout", 10, "HTTP timeout in seconds for fetching a feed from a Nitter instance")
 	user := flag.String("user", "", "User to fetch to stdout (instead of starting a server)")
 	flag.Parse()
 
-	opts.format = feedFormat(*format)
-	opts.timeout = time.Duration(*timeout) * time.Second
+	var err error
+	if flagOpts.format, err = parseFeedFormat(*format); err != nil {
+		log.Fatal("Bad -format: ", err)
+	}
+	if flagOpts.retweets, err = parseRetweetMode(*retweets); err != nil {
+		log.Fatal("Bad -retweets: ", err)
+	}
+	if flagOpts.imageSize, err = parseImageSize(*imageSize); err != nil {
+		log.Fatal("Bad -image-size: ", err)
+	}
+	if flagOpts.target, err = parseRewriteTarget(*rewriteTarget); err != nil {
+		log.Fatal("Bad -rewrite-target: ", err)
+	}
+	sockMode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		log.Fatal("Bad -socket-mode: ", err)
+	}
+	flagOpts.timeout = time.Duration(*timeout) * time.Second
+	flagOpts.hedgeDelay = time.Duration(*hedgeDelay) * time.Second
+	flagOpts.deadline = time.Duration(*deadline) * time.Second
+	flagOpts.cacheTTL = time.Duration(*cacheTTL) * time.Second
+	flagOpts.cacheStale = time.Duration(*cacheStale) * time.Second
+	flagOpts.cacheSize = *cacheSize
+	flagOpts.cacheDir = *cacheDir
+	flagOpts.circuitBackoff = time.Duration(*circuitBackoff) * time.Second
+	flagOpts.mediaMaxSize = int64(*mediaMaxSize) << 20
 
-	hnd, err := newHandler(*base, *instances, opts)
+	// loadConfig returns the handler configuration from flags, overridden by the config file.
+	// addr is only updated if non-nil.
+	loadConfig := func(addr *string) (base, instances string, opts handlerOptions, err error) {
+		base, instances, opts = *flagBase, *flagInstances, flagOpts
+		if *configPath != "" {
+			cfg, err := readConfig(*configPath)
+			if err != nil {
+				return "", "", opts, err
+			}
+			cfg.apply(addr, &base, &instances, &opts)
+		}
+		return base, instances, opts, nil
+	}
+
+	base, instances, opts, err := loadConfig(addr)
+	if err != nil {
+		log.Fatal("Failed loading config: ", err)
+	}
+	hnd, err := newHandler(base, instances, opts)
 	if err != nil {
 		log.Fatal("Failed creating handler: ", err)
 	}
+	rh := &reloadableHandler{hnd: hnd}
 
 	if *user != "" {
 		w := newFakeResponseWriter()
 		req, _ := http.NewRequest(http.MethodGet, "/"+*user, nil)
 		hnd.ServeHTTP(w, req)
+		hnd.wait()
 		if w.status != http.StatusOK {
 			log.Fatal(w.msg)
 		}
-	} else if *fastCGI {
-		log.Fatal("Failed serving over FastCGI: ", fcgi.Serve(nil, hnd))
+		return
+	}
+
+	// shutdown stops accepting new requests and waits for in-flight ones to finish.
+	var shutdown func(ctx context.Context) error
+	errc := make(chan error, 1)
+	if *fastCGI {
+		var ln net.Listener
+		if systemdFDs() > 0 || strings.HasPrefix(*addr, unixAddrPrefix) {
+			ln, err = listen(*addr, os.FileMode(sockMode))
+		} else {
+			// The web server passes the listening socket as stdin.
+			ln, err = net.FileListener(os.Stdin)
+		}
+		if err != nil {
+			log.Fatal("Failed getting FastCGI listener: ", err)
+		}
+		go func() { errc <- fmt.Errorf("serving over FastCGI: %v", fcgi.Serve(ln, rh)) }()
+		shutdown = func(ctx context.Context) error {
+			ln.Close()
+			return rh.drain(ctx)
+		}
 	} else {
-		srv := &http.Server{Addr: *addr, Handler: hnd}
-		log.Fatalf("Failed serving on %v: %v", *addr, srv.ListenAndServe())
+		ln, err := listen(*addr, os.FileMode(sockMode))
+		if err != nil {
+			log.Fatalf("Failed listening on %v: %v", *addr, err)
+		}
+		srv := &http.Server{Handler: rh}
+		go func() { errc <- fmt.Errorf("serving on %v: %v", ln.Addr(), srv.Serve(ln)) }()
+		shutdown = srv.Shutdown
+	}
+
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case err := <-errc:
+			log.Fatal("Failed ", err)
+		case sig := <-sc:
+			if sig == syscall.SIGHUP {
+				if *configPath == "" {
+					log.Print("Ignoring SIGHUP without -config")
+					continue
+				}
+				log.Print("Reloading ", *configPath)
+				base, instances, opts, err := loadConfig(nil)
+				if err != nil {
+					log.Print("Failed loading config: ", err)
+					continue
+				}
+				nh, err := rh.get().reload(base, instances, opts)
+				if err != nil {
+					log.Print("Failed reloading handler: ", err)
+					continue
+				}
+				rh.set(nh)
+				continue
+			}
+
+			log.Printf("Got %v; shutting down", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*drainTimeout)*time.Second)
+			if err := shutdown(ctx); err != nil {
+				log.Print("Failed finishing requests: ", err)
+			}
+			// Let background refreshes finish writing to the cache.
+			if err := rh.drain(ctx); err != nil {
+				log.Print("Failed finishing refreshes: ", err)
+			}
+			cancel()
+			return
+		}
 	}
 }
 
 // handler implements http.Handler to accept GET requests for RSS feeds.
 type handler struct {
+	*handlerState
 	base      *url.URL
 	client    http.Client
 	instances []*url.URL
@@ -88,22 +280,89 @@ type handler struct {
 	mu        sync.Mutex // protects start
 }
 
+// handlerState contains state that's shared between a handler and its replacements
+// when the configuration is reloaded.
+type handlerState struct {
+	cache   *feedCache
+	health  *healthTracker
+	metrics *metrics
+	recent  *requestLog    // recently-served feeds
+	quotes  *quoteCache    // quoted tweets fetched for inlining
+	wg      sync.WaitGroup // tracks background refreshes
+}
+
 type handlerOptions struct {
 	cycle        bool // cycle through instances
 	timeout      time.Duration
+	deadline     time.Duration // overall time to spend getting a requested feed (0 for no limit)
+	hedgeDelay   time.Duration // time to wait for an instance before also trying the next (0 to disable)
 	format       feedFormat
-	rewrite      bool // rewrite tweet content to point at Twitter
-	debugAuthors bool // log per-author tweet counts
+	rewrite      bool           // rewrite URLs in tweet content
+	target       *rewriteTarget // where rewritten URLs point (nil for twitter.com)
+	retweets     retweetMode    // how retweets are handled
+	imageSize    imageSize      // size of images in rewritten content
+	debugAuthors bool           // log per-author tweet counts
+	maxForeign   float64        // max fraction of foreign items in fetched feeds (0 to disable)
+	quotes       int            // max quoted tweets to fetch per feed (0 to disable)
+	threads      bool           // fold chains of self-replies into single items
+	cacheTTL     time.Duration  // time for which fetched feeds are fresh (0 to disable caching)
+	cacheStale   time.Duration  // time past cacheTTL for which stale feeds are served while refreshing
+	cacheSize    int            // max number of cached feeds
+	cacheDir     string         // directory for persisting cached feeds (empty to disable)
+
+	circuitFailures int           // consecutive failures before an instance is skipped
+	circuitBackoff  time.Duration // initial time for which failing instances are skipped
+
+	mediaProxy   bool  // serve media via the /media/ endpoint
+	mediaMaxSize int64 // max bytes of media to serve (0 for no limit)
+
+	merge         bool // fetch comma-separated users individually and merge their feeds
+	mergeMax      int  // max items in merged feeds (0 for no limit)
+	mergeParallel int  // max concurrent fetches for merged feeds (0 for no limit)
+
+	feeds   map[string]*feedConfig   // per-feed overrides keyed by lowercase user path
+	bundles map[string]*bundleConfig // bundles keyed by lowercase name
 }
 
 func newHandler(base, instances string, opts handlerOptions) (*handler, error) {
+	cache, err := newFeedCache(opts.cacheTTL, opts.cacheStale, opts.cacheSize, opts.cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating cache: %v", err)
+	}
+	return buildHandler(base, instances, opts, &handlerState{
+		cache:   cache,
+		health:  newHealthTracker(opts.circuitFailures, opts.circuitBackoff),
+		metrics: newMetrics(),
+		recent:  newRequestLog(recentRequests),
+		quotes:  newQuoteCache(),
+	})
+}
+
+// reload returns a new handler with the supplied configuration.
+// The new handler shares hnd's cache, instance health, and metrics.
+func (hnd *handler) reload(base, instances string, opts handlerOptions) (*handler, error) {
+	if opts.cacheDir != hnd.opts.cacheDir {
+		log.Print("Cache directory can't be changed without restarting")
+		opts.cacheDir = hnd.opts.cacheDir
+	}
+	nh, err := buildHandler(base, instances, opts, hnd.handlerState)
+	if err != nil {
+		return nil, err
+	}
+	hnd.cache.setLimits(opts.cacheTTL, opts.cacheStale, opts.cacheSize)
+	hnd.health.setLimits(opts.circuitFailures, opts.circuitBackoff)
+	return nh, nil
+}
+
+func buildHandler(base, instances string, opts handlerOptions, st *handlerState) (*handler, error) {
 	hnd := &handler{
-		client: http.Client{Timeout: opts.timeout},
-		opts:   opts,
+		handlerState: st,
+		client:       http.Client{Timeout: opts.timeout},
+		opts:         opts,
 	}
 
+	var err error
 	if base != "" {
-		var err error
 		if hnd.base, err = url.Parse(base); err != nil {
 			return nil, fmt.Errorf("failed parsing %q: %v", base, err)
 		}
@@ -123,20 +382,56 @@ func newHandler(base, instances string, opts handlerOptions) (*handler, error) {
 	if len(hnd.instances) == 0 {
 		return nil, errors.New("no instances supplied")
 	}
+	if opts.mediaProxy && hnd.base == nil {
+		return nil, errors.New("media proxy requires base URL")
+	}
 
 	return hnd, nil
 }
 
 var (
 	// Matches comma-separated Twitter usernames with an optional /media, /search, or /with_replies suffix
-	// supported by Nitter's RSS handler (https://github.com/zedeus/nitter/blob/master/src/routes/rss.nim).
+	// supported by Nitter's RSS handler (https://github.com/zedeus/nitter/blob/master/src/routes/rss.nim),
+	// optionally followed by an extension specifying the feed format (e.g. ".json").
 	// Ignores any leading junk that might be present in the path e.g. when proxying a prefix to FastCGI.
-	userRegexp = regexp.MustCompile(`[_a-zA-Z0-9,]+(/(media|search|with_replies))?$`)
+	userRegexp = regexp.MustCompile(`([_a-zA-Z0-9,]+(?:/(?:media|search|with_replies))?)(?:\.(atom|json|rss))?$`)
 
-	// Matches valid query parameters to forward to Nitter.
+	// Matches the name of a bundle of feeds defined in the config file, optionally followed
+	// by an extension specifying the feed format.
+	bundleRegexp = regexp.MustCompile(`(?:^|/)bundle/([-_a-zA-Z0-9]+)(?:\.(atom|json|rss))?$`)
+
+	// Matches a single valid query parameter to forward to Nitter.
+	// Other parameters (e.g. "format") are handled by the proxy and aren't forwarded.
 	queryRegexp = regexp.MustCompile(`^max_position=[^&]+$`)
 )
 
+// forwardedQuery returns the parameters from the raw query that should be forwarded to Nitter.
+func forwardedQuery(raw string) string {
+	var params []string
+	for _, p := range strings.Split(raw, "&") {
+		if queryRegexp.MatchString(p) {
+			params = append(params, p)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// requestFormat returns the format in which the feed should be written in response to req.
+// In order of precedence, the "format" query parameter, the path's extension, and the Accept
+// header are used. def is returned if none of them specify a format.
+func requestFormat(req *http.Request, ext string, def feedFormat) (feedFormat, error) {
+	if s := req.URL.Query().Get("format"); s != "" {
+		return parseFeedFormat(s)
+	}
+	if ext != "" {
+		return parseFeedFormat(ext)
+	}
+	if f := acceptFormat(req.Header.Get("Accept"), def); f != "" {
+		return f, nil
+	}
+	return def, nil
+}
+
 func (hnd *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
 		http.Error(w, "Only GET supported", http.StatusMethodNotAllowed)
@@ -149,79 +444,570 @@ func (hnd *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	user := userRegexp.FindString(req.URL.Path)
-	if user == "" {
+	switch hnd.endpoint(req.URL.Path) {
+	case "/metrics":
+		hnd.metrics.ServeHTTP(w, req)
+		return
+	case "/status", "/status.json":
+		hnd.serveStatus(w, req)
+		return
+	}
+	if p := hnd.endpoint(req.URL.Path); hnd.opts.mediaProxy && strings.HasPrefix(p, mediaPrefix) {
+		hnd.serveMedia(w, req, p[len(mediaPrefix):])
+		return
+	}
+
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	rec := requestRecord{Time: time.Now(), Path: req.URL.Path}
+	hnd.serveFeed(sw, req, &rec)
+	rec.Status = sw.status
+	hnd.metrics.requests.inc(strconv.Itoa(rec.Status), string(rec.Format))
+	hnd.recent.add(rec)
+}
+
+// endpoint returns p relative to the path of hnd.base (if any), e.g. "/metrics".
+func (hnd *handler) endpoint(p string) string {
+	if hnd.base != nil {
+		if bp := strings.TrimSuffix(hnd.base.Path, "/"); bp != "" && strings.HasPrefix(p, bp+"/") {
+			return p[len(bp):]
+		}
+	}
+	return p
+}
+
+// serveFeed writes the feed requested by req to w.
+// The feed's format and source are saved to rec.
+func (hnd *handler) serveFeed(w http.ResponseWriter, req *http.Request, rec *requestRecord) {
+	var (
+		name  string   // feed's path relative to hnd.base, e.g. "someuser" or "bundle/somebundle"
+		users []string // individual user paths to fetch
+		ext   string   // extension from path
+		fo    feedOptions
+	)
+	if ms := bundleRegexp.FindStringSubmatch(req.URL.Path); ms != nil {
+		bc := hnd.opts.bundles[strings.ToLower(ms[1])]
+		if bc == nil {
+			http.Error(w, "Unknown bundle", http.StatusNotFound)
+			return
+		}
+		name, ext = "bundle/"+ms[1], ms[2]
+		for _, u := range bc.Users {
+			users = append(users, splitUsers(u)...)
+		}
+		fo = hnd.configOptions(&bc.feedConfig)
+		if fo.title == "" {
+			fo.title = ms[1]
+		}
+	} else if ms := userRegexp.FindStringSubmatch(req.URL.Path); ms != nil {
+		name, ext = ms[1], ms[2]
+		users = []string{name}
+		if hnd.opts.merge {
+			users = splitUsers(name)
+		}
+		fo = hnd.optionsFor(name)
+	}
+	if len(users) == 0 { // e.g. no match, or "/," with merging enabled
 		http.Error(w, "Invalid user", http.StatusBadRequest)
 		return
 	}
-	var query string
-	if queryRegexp.MatchString(req.URL.RawQuery) {
-		query = req.URL.RawQuery
+
+	query := forwardedQuery(req.URL.RawQuery)
+	def := fo.format
+	var err error
+	if fo.format, err = requestFormat(req, ext, fo.format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := fo.addQueryFilter(req.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s := req.URL.Query().Get("retweets"); s != "" {
+		if fo.retweets, err = parseRetweetMode(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	rec.Format = fo.format
+	if fo.quotes > 0 {
+		fo.quoteBudget = newQuoteBudget(fo.quotes)
+	}
+
+	// Stop fetching if the client goes away or the overall deadline is reached.
+	ctx := req.Context()
+	if hnd.opts.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hnd.opts.deadline)
+		defer cancel()
+	}
+	getFailed := func(err error) {
+		log.Printf("Failed getting %v: %v", name, err)
+		if ctx.Err() == context.DeadlineExceeded {
+			http.Error(w, "Timed out getting feed", http.StatusGatewayTimeout)
+		} else {
+			http.Error(w, "Couldn't get feed from any instances", http.StatusInternalServerError)
+		}
+	}
+
+	var feed *feeds.Feed
+	var minID string
+	if len(users) > 1 {
+		// Many instances don't support multi-user timelines, so fetch each user separately.
+		if feed, err = hnd.mergeFeeds(ctx, users, name, query, fo, rec); err != nil {
+			getFailed(err)
+			return
+		}
+	} else {
+		user := users[0]
+		ff, cached, err := hnd.getFeed(ctx, fo.fetchPath(user), query)
+		if err != nil {
+			getFailed(err)
+			return
+		}
+		if ff.instance != nil {
+			rec.Instance = ff.instance.String()
+		}
+		rec.Cached = cached
+
+		if feed, err = hnd.rewrite(ctx, ff.feed, user, ff.loc, fo); err != nil {
+			log.Printf("Failed rewriting %v from %v: %v", user, ff.loc, err)
+			http.Error(w, "Couldn't rewrite feed", http.StatusInternalServerError)
+			return
+		}
+		minID = ff.minID
+	}
+
+	etag, mod := feedValidators(feed, fo.format)
+	if minID != "" {
+		w.Header().Set(minIDHeader, minID)
+	}
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("ETag", etag)
+	if !mod.IsZero() {
+		w.Header().Set("Last-Modified", mod.UTC().Format(http.TimeFormat))
+	}
+	if notModified(req, etag, mod) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if err := hnd.write(w, feed, name, fo.format, def); err != nil {
+		log.Printf("Failed writing %v: %v", name, err)
+		http.Error(w, "Couldn't write feed", http.StatusInternalServerError)
+	}
+}
+
+// feedOptions contains options used when rewriting and writing an individual feed.
+type feedOptions struct {
+	format    feedFormat
+	title     string         // replaces feed's title if non-empty
+	rewrite   bool           // rewrite URLs in tweet content
+	target    *rewriteTarget // where rewritten URLs point (nil for twitter.com)
+	retweets  retweetMode    // how retweets are handled
+	imageSize imageSize      // size of images in rewritten content
+	quotes    int            // max quoted tweets to fetch (0 to disable)
+	threads   bool           // fold chains of self-replies into single items
+	filters   []*itemFilter  // items must be kept by all filters
+
+	// quoteBudget limits quoted tweets fetched for the request (nil if quotes is 0).
+	// It's shared by all of the users' feeds in a merged feed.
+	quoteBudget *quoteBudget
+}
+
+// optionsFor returns options for user's feed, combining hnd.opts with per-feed overrides.
+func (hnd *handler) optionsFor(user string) feedOptions {
+	return hnd.configOptions(hnd.opts.feeds[strings.ToLower(user)])
+}
+
+// configOptions returns feed options combining hnd.opts with overrides from fc, which may be nil.
+func (hnd *handler) configOptions(fc *feedConfig) feedOptions {
+	fo := feedOptions{
+		format:    hnd.opts.format,
+		rewrite:   hnd.opts.rewrite,
+		target:    hnd.opts.target,
+		retweets:  hnd.opts.retweets,
+		imageSize: hnd.opts.imageSize,
+		quotes:    hnd.opts.quotes,
+		threads:   hnd.opts.threads,
+	}
+	if fc != nil {
+		if fc.format != "" {
+			fo.format = fc.format
+		}
+		fo.title = fc.Title
+		if fc.Rewrite != nil {
+			fo.rewrite = *fc.Rewrite
+		}
+		if fc.RewriteTarget != "" {
+			fo.target = fc.target
+		}
+		if fc.retweets != "" {
+			fo.retweets = fc.retweets
+		}
+		if fc.ImageSize != "" {
+			fo.imageSize = fc.imageSize
+		}
+		if fc.Quotes != nil {
+			fo.quotes = *fc.Quotes
+		}
+		if fc.Threads != nil {
+			fo.threads = *fc.Threads
+		}
+		if fc.filter != nil {
+			fo.filters = append(fo.filters, fc.filter)
+		}
+	}
+	return fo
+}
+
+// fetchPath returns the path to fetch from Nitter for user (e.g. "someuser").
+func (fo *feedOptions) fetchPath(user string) string {
+	if fo.threads {
+		return threadPath(user)
+	}
+	return user
+}
+
+// addQueryFilter adds a filter from q's parameters (if any) to fo.
+func (fo *feedOptions) addQueryFilter(q url.Values) error {
+	fc, err := queryFilterConfig(q)
+	if err != nil || fc == nil {
+		return err
+	}
+	f, err := fc.compile()
+	if err != nil {
+		return err
+	}
+	fo.filters = append(fo.filters, f)
+	return nil
+}
+
+// keep returns true if oi should be included in a feed containing tweets from users
+// per fo.filters. marked is passed to isRetweet.
+func (fo *feedOptions) keep(oi *gofeed.Item, users []string, marked bool) bool {
+	for _, f := range fo.filters {
+		if !f.keep(oi, users, marked) {
+			return false
+		}
+	}
+	return true
+}
+
+// feedValidators returns an ETag and last-modified time for feed written in format.
+// The ETag is derived from the feed's title, link, and image and its items' IDs, titles,
+// links, authors, update times, and content (so it changes when e.g. a folded thread grows
+// or the config is reloaded), and the last-modified time is the newest item's creation
+// or update time (or zero if the feed has no items).
+func feedValidators(feed *feeds.Feed, format feedFormat) (etag string, mod time.Time) {
+	h := sha256.New()
+	// Length-prefix each value so that adjacent values can't run together.
+	add := func(s string) { fmt.Fprintf(h, "%d:%s\n", len(s), s) }
+	add(string(format))
+	add(feed.Title)
+	var link, image string
+	if feed.Link != nil {
+		link = feed.Link.Href
+	}
+	if feed.Image != nil {
+		image = feed.Image.Url
+	}
+	add(link)
+	add(image)
+	for _, item := range feed.Items {
+		var link, author string
+		if item.Link != nil {
+			link = item.Link.Href
+		}
+		if item.Author != nil {
+			author = item.Author.Name
+		}
+		add(item.Id)
+		add(item.Title)
+		add(link)
+		add(author)
+		add(strconv.FormatInt(item.Updated.UnixNano(), 10))
+		add(item.Content)
+		if item.Created.After(mod) {
+			mod = item.Created
+		}
+		if item.Updated.After(mod) {
+			mod = item.Updated
+		}
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`, mod
+}
+
+// notModified returns true if req's conditional headers indicate that the client already
+// has the version of the feed identified by etag and mod.
+func notModified(req *http.Request, etag string, mod time.Time) bool {
+	// If-Modified-Since is ignored when If-None-Match is present (RFC 7232 section 6).
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" && !mod.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil {
+			return !mod.Truncate(time.Second).After(t)
+		}
 	}
+	return false
+}
 
+// getFeed returns user's feed, fetched with query.
+// The feed is returned from hnd.cache if possible. Stale cached feeds are returned
+// immediately and refreshed in the background, and expired cached feeds are returned
+// if the feed can't be fetched from any instances. cached is true if the feed came from
+// the cache. Fetching stops if ctx is canceled, but background refreshes continue.
+func (hnd *handler) getFeed(ctx context.Context, user, query string) (
+	ff *fetchedFeed, cached bool, err error) {
+	key := cacheKey(user, query)
+	entry, state := hnd.cache.get(key, time.Now())
+	if state == cacheFresh || state == cacheStale {
+		hnd.metrics.cacheHits.inc()
+	} else {
+		hnd.metrics.cacheMisses.inc()
+	}
+	switch state {
+	case cacheFresh:
+		return entry, true, nil
+	case cacheStale:
+		if hnd.cache.startRefresh(key) {
+			hnd.wg.Add(1)
+			go func() {
+				defer hnd.wg.Done()
+				defer hnd.cache.finishRefresh(key)
+				if ff, err := hnd.fetchAny(context.Background(), user, query); err != nil {
+					log.Printf("Failed refreshing %v: %v", key, err)
+				} else {
+					hnd.cache.set(key, ff)
+				}
+			}()
+		}
+		return entry, true, nil
+	}
+
+	if ff, err = hnd.fetchAny(ctx, user, query); err != nil {
+		if entry != nil {
+			log.Printf("Using feed for %v cached at %v", key, entry.fetched.Format(time.RFC3339))
+			return entry, true, nil
+		}
+		// If every instance returned a polluted feed, serve the least-bad one without caching it.
+		var fe *foreignError
+		if errors.As(err, &fe) && fe.ff != nil {
+			log.Printf("Using rejected feed for %v from %v: %v", key, fe.ff.instance, fe)
+			return fe.ff, false, nil
+		}
+		return nil, false, err
+	}
+	hnd.cache.set(key, ff)
+	return ff, false, nil
+}
+
+// wait waits for background refreshes started by getFeed to complete.
+func (hnd *handler) wait() { hnd.wg.Wait() }
+
+// fetchAny tries to fetch and parse user's feed from each instance in turn.
+// Instances are ordered by their health, with hnd.start used to break ties.
+// If hnd.opts.hedgeDelay is positive, the next instance is tried whenever the
+// previous one hasn't responded within the delay, and the first valid feed is used.
+// ctx's error is returned if it's canceled or its deadline is reached. If all instances
+// returned feeds that were rejected by checkForeign, the least-polluted one's *foreignError
+// is returned.
+func (hnd *handler) fetchAny(ctx context.Context, user, query string) (*fetchedFeed, error) {
+	hnd.mu.Lock()
 	start := hnd.start
 	if hnd.opts.cycle {
-		hnd.mu.Lock()
 		hnd.start = (hnd.start + 1) % len(hnd.instances)
-		hnd.mu.Unlock()
 	}
+	hnd.mu.Unlock()
 
-	for i := 0; i < len(hnd.instances); i++ {
-		in := hnd.instances[(start+i)%len(hnd.instances)]
-		b, loc, minID, err := hnd.fetch(in, user, query)
-		if err != nil {
-			log.Printf("Failed fetching %v from %v: %v", user, in, err)
-			continue
+	insts := hnd.health.order(hnd.instances, start, time.Now())
+	if hnd.opts.hedgeDelay > 0 {
+		return hnd.fetchHedged(ctx, insts, user, query)
+	}
+	var rejected *foreignError
+	for _, in := range insts {
+		if ff, err := hnd.fetchFrom(ctx, in, user, query); err == nil {
+			return ff, nil
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
+		} else {
+			rejected = leastForeign(rejected, err)
 		}
-		w.Header().Set(minIDHeader, minID)
-		if err := hnd.rewrite(w, b, user, loc); err != nil {
-			log.Printf("Failed rewriting %v from %v: %v", user, in, err)
-			continue
+	}
+	if rejected != nil {
+		return nil, rejected
+	}
+	return nil, errors.New("all instances failed")
+}
+
+// fetchHedged starts fetching user's feed from each of insts in turn, starting the next
+// fetch after hnd.opts.hedgeDelay (or immediately after a failure). The first valid feed
+// is returned and the remaining fetches are canceled.
+func (hnd *handler) fetchHedged(ctx context.Context, insts []*url.URL, user, query string) (
+	*fetchedFeed, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		ff  *fetchedFeed
+		err error
+	}
+	ch := make(chan result, len(insts)) // buffered so canceled fetches don't block
+	var next, pending int
+	var hedge <-chan time.Time // fires when the next fetch should be started
+	startNext := func() {
+		in := insts[next]
+		next++
+		pending++
+		go func() {
+			ff, err := hnd.fetchFrom(ctx, in, user, query)
+			ch <- result{ff, err}
+		}()
+		if next < len(insts) {
+			hedge = time.After(hnd.opts.hedgeDelay)
+		} else {
+			hedge = nil
 		}
-		return
 	}
-	http.Error(w, "Couldn't get feed from any instances", http.StatusInternalServerError)
+
+	if len(insts) > 0 {
+		startNext()
+	}
+	done := ctx.Done()
+	var rejected *foreignError
+	for pending > 0 {
+		select {
+		case res := <-ch:
+			pending--
+			if res.err == nil {
+				return res.ff, nil
+			}
+			rejected = leastForeign(rejected, res.err)
+			if next < len(insts) && ctx.Err() == nil {
+				startNext()
+			}
+		case <-done:
+			// Stop starting new fetches and wait for pending ones to be canceled.
+			done, hedge = nil, nil
+		case <-hedge:
+			log.Printf("No response for %v after %v; trying another instance", user, hnd.opts.hedgeDelay)
+			startNext()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if rejected != nil {
+		return nil, rejected
+	}
+	return nil, errors.New("all instances failed")
+}
+
+// fetchFrom fetches and parses user's feed from instance in, updating metrics and
+// instance health. Fetches that are canceled via ctx don't affect in's health.
+func (hnd *handler) fetchFrom(ctx context.Context, in *url.URL, user, query string) (*fetchedFeed, error) {
+	now := time.Now()
+	hnd.health.begin(in, now)
+	hnd.metrics.fetches.inc(in.String())
+	b, loc, minID, err := hnd.fetch(ctx, in, user, query)
+	if err != nil && ctx.Err() != nil {
+		hnd.health.cancel(in)
+		return nil, err
+	}
+	hnd.metrics.fetchDuration.observeDuration(time.Since(now), in.String())
+	if err != nil {
+		hnd.metrics.fetchFailures.inc(in.String())
+		log.Printf("Failed fetching %v from %v: %v", user, in, err)
+		hnd.health.failure(in, err, time.Now())
+		return nil, err
+	}
+	of, err := gofeed.NewParser().ParseString(string(b))
+	if err != nil {
+		hnd.metrics.fetchFailures.inc(in.String())
+		log.Printf("Failed parsing %v from %v: %v", user, in, err)
+		hnd.health.failure(in, err, time.Now())
+		return nil, err
+	}
+	// Treat feeds polluted with other users' tweets as failures so another instance is tried.
+	ff := &fetchedFeed{body: b, feed: of, instance: in, loc: loc, minID: minID, fetched: now}
+	if err := checkForeign(of, user, hnd.opts.maxForeign); err != nil {
+		hnd.metrics.fetchFailures.inc(in.String())
+		hnd.metrics.rejectedFeeds.inc(in.String())
+		log.Printf("Rejecting %v from %v: %v", user, in, err)
+		hnd.health.failure(in, err, time.Now())
+		if fe, ok := err.(*foreignError); ok {
+			fe.ff = ff
+		}
+		return nil, err
+	}
+	hnd.health.success(in, time.Since(now), time.Now())
+	return ff, nil
+}
+
+// statusError is returned by fetch when an instance returns a non-200 status code.
+type statusError struct {
+	code   int    // e.g. 404
+	status string // e.g. "404 Not Found"
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server returned %v (%v)", e.code, e.status)
 }
 
 // fetch fetches user's feed from supplied Nitter instance.
 // user follows the format used by Nitter: it can be a single username or a comma-separated
 // list of usernames, with an optional /media, /search, or /with_replies suffix.
 // If query is non-empty, it will be passed to the instance.
+// The request is canceled if ctx is canceled.
 // The response body, final location (after redirects), and Min-Id header value are returned.
-func (hnd *handler) fetch(instance *url.URL, user, query string) (
+func (hnd *handler) fetch(ctx context.Context, instance *url.URL, user, query string) (
 	body []byte, loc *url.URL, minID string, err error) {
 	u := *instance
 	u.Path = path.Join(u.Path, user, "rss")
 	u.RawQuery = query
 
 	log.Print("Fetching ", u.String())
-	resp, err := hnd.client.Get(u.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	resp, err := hnd.client.Do(req)
 	if err != nil {
 		return nil, nil, "", err
 	}
 	defer resp.Body.Close()
 	loc = resp.Request.URL
 	if resp.StatusCode != http.StatusOK {
-		return nil, loc, "", fmt.Errorf("server returned %v (%v)", resp.StatusCode, resp.Status)
+		return nil, loc, "", &statusError{resp.StatusCode, resp.Status}
 	}
 	body, err = ioutil.ReadAll(resp.Body)
 	return body, loc, resp.Header.Get(minIDHeader), err
 }
 
-// rewrite parses user's feed from b and rewrites it to w.
-func (hnd *handler) rewrite(w http.ResponseWriter, b []byte, user string, loc *url.URL) error {
-	of, err := gofeed.NewParser().ParseString(string(b))
-	if err != nil {
-		return err
-	}
-
+// rewrite converts user's feed of (fetched from loc) to a feeds.Feed using fo.
+// ctx is used when fetching quoted tweets.
+func (hnd *handler) rewrite(ctx context.Context, of *gofeed.Feed, user string, loc *url.URL,
+	fo feedOptions) (*feeds.Feed, error) {
 	log.Printf("Rewriting %v item(s) for %v", len(of.Items), user)
+	target := fo.target.forLoc(loc)
+	if hnd.opts.mediaProxy {
+		target = target.withMedia(hnd.mediaBase())
+	}
 
 	feed := &feeds.Feed{
 		Title:       of.Title,
-		Link:        &feeds.Link{Href: rewriteTwitterURL(of.Link)},
+		Link:        &feeds.Link{Href: target.rewrite(of.Link)},
 		Description: "Twitter feed for " + user,
 	}
+	if fo.title != "" {
+		feed.Title = fo.title
+	}
 	if of.UpdatedParsed != nil {
 		feed.Updated = *of.UpdatedParsed
 	}
@@ -229,35 +1015,74 @@ func (hnd *handler) rewrite(w http.ResponseWriter, b []byte, user string, loc *u
 		feed.Author = &feeds.Author{Name: of.Author.Name}
 	}
 
-	var img string
 	if of.Image != nil {
-		img = rewriteIconURL(of.Image.URL)
-		feed.Image = &feeds.Image{Url: img}
+		feed.Image = &feeds.Image{Url: target.mapURL(rewriteIconURL(of.Image.URL))}
 	}
 
+	users := feedUsers(user)
+	marked := marksRetweets(of.Items)
 	authorCnt := make(map[string]int)
+	var foreign int
+	items := of.Items
+	if fo.threads {
+		items = foldThreads(items)
+	}
+	// Replies to other users are only present because threadPath switched to the
+	// with_replies timeline.
+	dropReplies := fo.fetchPath(user) != user
+
+	var kept []*gofeed.Item
+	for _, oi := range items {
+		if !fo.keep(oi, users, marked) {
+			continue
+		}
+		if rt := replyTo(oi.Title); dropReplies && rt != "" && !hasUser(users, rt) {
+			continue
+		}
+		if fo.retweets == retweetsDrop && isRetweet(oi, users, marked) {
+			continue
+		}
+		kept = append(kept, oi)
+	}
+
+	var quoted map[*gofeed.Item]string // contents with quoted tweets
+	if fo.quoteBudget != nil {
+		quoted = hnd.expandQuotes(ctx, kept, loc, fo.quoteBudget)
+	}
+
+	for _, oi := range kept {
+		retweet := isRetweet(oi, users, marked)
 
-	for _, oi := range of.Items {
 		// The Content field seems to be empty. gofeed appears to instead return the
 		// content (often including HTML) in the Description field.
 		content := oi.Description
-		if hnd.opts.rewrite {
-			if content, err = rewriteContent(oi.Description, loc); err != nil {
-				return err
+		if s, ok := quoted[oi]; ok {
+			content = s
+		}
+		if fo.rewrite {
+			var err error
+			if content, err = rewriteContent(content, loc, target, fo.imageSize); err != nil {
+				return nil, err
 			}
 		}
 
+		author := itemAuthor(oi)
+		title := oi.Title
+		if retweet && fo.retweets == retweetsAnnotate && author != "" {
+			title = annotateRetweet(title, author)
+		}
+
 		item := &feeds.Item{
-			Title:   oi.Title,
-			Link:    &feeds.Link{Href: rewriteTwitterURL(oi.Link)},
-			Id:      rewriteTwitterURL(oi.GUID),
+			Title:   title,
+			Link:    &feeds.Link{Href: target.rewrite(oi.Link)},
+			Id:      itemID(oi),
 			Content: content,
 		}
 
 		// When writing a JSON feed, the feeds package seems to expect the Description field to
 		// contain text rather than HTML.
-		if hnd.opts.format == jsonFormat {
-			item.Description = oi.Title
+		if fo.format == jsonFormat {
+			item.Description = title
 		} else {
 			item.Description = content
 		}
@@ -269,14 +1094,13 @@ func (hnd *handler) rewrite(w http.ResponseWriter, b []byte, user string, loc *u
 			item.Updated = *oi.UpdatedParsed
 		}
 
-		if oi.Author != nil && oi.Author.Name != "" {
-			item.Author = &feeds.Author{Name: oi.Author.Name}
-		} else if oi.DublinCoreExt != nil && len(oi.DublinCoreExt.Creator) > 0 {
-			// Nitter seems to use <dc:creator> for the original author in retweets.
-			item.Author = &feeds.Author{Name: oi.DublinCoreExt.Creator[0]}
+		if author != "" {
+			item.Author = &feeds.Author{Name: author}
+			authorCnt[author] += 1
+		}
+		if isForeign(oi, users, marked) {
+			foreign++
 		}
-
-		authorCnt[item.Author.Name] += 1
 
 		// Nitter dumps the entire content into the title.
 		// This looks ugly in Feedly, so truncate it.
@@ -293,8 +1117,23 @@ func (hnd *handler) rewrite(w http.ResponseWriter, b []byte, user string, loc *u
 	if hnd.opts.debugAuthors {
 		log.Printf("Authors for %v: %v", user, authorCnt)
 	}
+	if foreign > 0 {
+		hnd.metrics.foreignItems.add(float64(foreign), strings.ToLower(user))
+	}
+	hnd.metrics.itemsRewritten.add(float64(len(feed.Items)), strings.ToLower(user))
 
-	switch hnd.opts.format {
+	return feed, nil
+}
+
+// write writes feed to w in the supplied format.
+// name is the feed's path relative to hnd.base and def is its default format.
+func (hnd *handler) write(w http.ResponseWriter, feed *feeds.Feed, name string, format, def feedFormat) error {
+	var img string
+	if feed.Image != nil {
+		img = feed.Image.Url
+	}
+
+	switch format {
 	case atomFormat:
 		af := (&feeds.Atom{Feed: feed}).AtomFeed()
 		af.Icon = img
@@ -310,7 +1149,10 @@ func (hnd *handler) rewrite(w http.ResponseWriter, b []byte, user string, loc *u
 		jf := (&feeds.JSON{Feed: feed}).JSONFeed()
 		if hnd.base != nil {
 			u := *hnd.base
-			u.Path = path.Join(u.Path, user)
+			u.Path = path.Join(u.Path, name)
+			if def != jsonFormat {
+				u.Path += "." + string(jsonFormat)
+			}
 			jf.FeedUrl = u.String()
 		}
 		jf.Favicon = img
@@ -323,7 +1165,7 @@ func (hnd *handler) rewrite(w http.ResponseWriter, b []byte, user string, loc *u
 		w.Header().Set("Content-Type", "application/rss+xml; charset=UTF-8")
 		return feed.WriteRss(w)
 	default:
-		return fmt.Errorf("unknown format %q", hnd.opts.format)
+		return fmt.Errorf("unknown format %q", format)
 	}
 }
 
@@ -336,14 +1178,12 @@ const (
 )
 
 // encPicRegexp matches weird Nitter RULs with base64-encoded image paths,
-// e.g. "https://example.org/pic/enc/bWVkaWEvRm1Jc0R3SldRQUFKV2w4LmpwZw==".
+// e.g. "https://example.org/pic/enc/bWVkaWEvRm1Jc0R3SldRQUFKV2w4LmpwZw==" or
+// "https://example.org/pic/orig/enc/bWVkaWEvRm1Jc0R3SldRQUFKV2w4LmpwZw==".
 // We can't use |end| here since \b expects \w on one side and \W on the other,
 // but we may have a URL ending with '=' followed by '"' (both \W).
 var encPicRegexp = regexp.MustCompile(start +
-	// TODO: https://github.com/zedeus/nitter/blob/master/src/utils.nim also has code
-	// for /video/enc/ and /pic/orig/enc/. I'm not bothering to decode those yet since
-	// there aren't any rewrite patterns to further rewrite the resulting URLs.
-	`(` + scheme + host + `/pic/)` + // group 1: start of URL
+	`(` + scheme + host + `/pic/(?:orig/)?)` + // group 1: start of URL
 	`enc/` +
 	// See "5. Base 64 Encoding with URL and Filename Safe Alphabet" from RFC 4648.
 	`([-_=a-zA-Z0-9]+)`) // group 2: base64-encoded end of URL
@@ -364,6 +1204,46 @@ func decodeEncPicURL(u string) string {
 	return ms[1] + string(dec)
 }
 
+// videoRegexp matches Nitter video URLs, which contain an HMAC signature followed by
+// the full video.twimg.com URL, either base64-encoded, e.g.
+// "https://example.org/video/enc/4F3B2A/aHR0cHM6Ly92aWRlby50d2ltZy5jb20vdHdlZXRfdmlkZW8vQWJDLm1wNA==",
+// or percent-encoded, e.g.
+// "https://example.org/video/4F3B2A/https%3A%2F%2Fvideo.twimg.com%2Ftweet_video%2FAbC.mp4".
+var videoRegexp = regexp.MustCompile(start +
+	scheme + host + `/video/` +
+	`(?:enc/[0-9a-fA-F]+/([-_=a-zA-Z0-9]+)` + // group 1: base64-encoded URL
+	`|[0-9a-fA-F]+/(https?%3A%2F%2F[-_.~%+a-zA-Z0-9]+))`) // group 2: percent-encoded URL
+
+// decodeVideoURL rewrites a URL matched by videoRegexp to instead be the corresponding
+// video.twimg.com URL, e.g. "https://video.twimg.com/ext_tw_video/123/pu/pl/AbC.m3u8?tag=12".
+// If the URL is not matched by videoRegexp or doesn't contain a twimg.com URL,
+// it will be returned unmodified.
+func decodeVideoURL(u string) string {
+	ms := videoRegexp.FindStringSubmatch(u)
+	if ms == nil {
+		return u
+	}
+	var dec string
+	if ms[1] != "" {
+		b, err := base64.URLEncoding.DecodeString(ms[1])
+		if err != nil {
+			log.Printf("Failed base64-decoding %q: %v", ms[1], err)
+			return u
+		}
+		dec = string(b)
+	} else {
+		var err error
+		if dec, err = url.QueryUnescape(ms[2]); err != nil {
+			log.Printf("Failed unescaping %q: %v", ms[2], err)
+			return u
+		}
+	}
+	if vu, err := url.Parse(dec); err != nil || !strings.HasSuffix(vu.Host, ".twimg.com") {
+		return u
+	}
+	return dec
+}
+
 // iconRegexp exactly matches a Nitter profile image URL,
 // e.g. "https://example.org/pic/profile_images%2F1234567890%2F_AbQ3eRu_400x400.jpg".
 // At some point, Nitter seems to have started adding "/pbs.twimg.com" after "/pic".
@@ -397,6 +1277,12 @@ var rewritePatterns = []struct {
 		encPicRegexp,
 		func(ms []string) string { return decodeEncPicURL(ms[0]) },
 	},
+	{
+		// Signed video URLs (including HLS playlists) contain the full video.twimg.com URL,
+		// so they can be decoded directly.
+		videoRegexp,
+		func(ms []string) string { return decodeVideoURL(ms[0]) },
+	},
 	{
 		// Nitter URL referring to a tweet, e.g.
 		// "https://example.org/someuser/status/1234567890#m" or
@@ -420,13 +1306,22 @@ var rewritePatterns = []struct {
 	},
 	{
 		// Nitter URL referring to an image, e.g.
-		// "https://example.org/pic/media%2FA3B6MFcQXBBcIa2.jpg".
+		// "https://example.org/pic/media%2FA3B6MFcQXBBcIa2.jpg" or
+		// "https://example.org/pic/orig/media%2FA3B6MFcQXBBcIa2.jpg" (for the original size).
 		regexp.MustCompile(start +
-			scheme + host + `/pic` + slash + `media` + slash +
-			`([-_a-zA-Z0-9]+)` + // group 1: image ID
-			`\.(jpg|png)` + // group 2: extension
+			scheme + host + `/pic` +
+			`(` + slash + `orig)?` + // group 1: original size
+			`(?:` + slash + `pbs\.twimg\.com)?` + slash + `media` + slash +
+			`([-_a-zA-Z0-9]+)` + // group 2: image ID
+			`\.(jpg|png)` + // group 3: extension
 			end),
-		func(ms []string) string { return fmt.Sprintf("https://pbs.twimg.com/media/%v?format=%v", ms[1], ms[2]) },
+		func(ms []string) string {
+			u := fmt.Sprintf("https://pbs.twimg.com/media/%v?format=%v", ms[2], ms[3])
+			if ms[1] != "" {
+				u += "&name=orig"
+			}
+			return u
+		},
 	},
 	{
 		// Nitter URL referring to a video, e.g.
@@ -497,33 +1392,169 @@ var rewritePatterns = []struct {
 // Some public Nitter instances seem to be misconfigured, e.g. rewriting URLs to
 // start with "http://localhost", so we just modify all URLs that look like they
 // can be served by Twitter.
-func rewriteContent(s string, loc *url.URL) (string, error) {
-	// It'd be better to parse the HTML instead of using regular expressions, but that's quite
-	// painful to do (see https://github.com/derat/twittuh) so I'm trying to avoid it for now.
+//
+// URLs in attributes like href and src are rewritten, as are URL-like text within links
+// (Nitter displays links' destinations as their text). Other text and markup are left alone.
+// URLs are mapped to target, which may be nil to use twitter.com.
+//
+// If size isn't imageSizeDefault, pbs.twimg.com images are requested in size, given srcset
+// attributes listing larger sizes, and wrapped in links to their original-resolution versions.
+func rewriteContent(s string, loc *url.URL, target *rewriteTarget, size imageSize) (string, error) {
+	ur, err := newURLRewriter(loc, target)
+	if err != nil {
+		return s, err
+	}
+
+	var b strings.Builder
+	var links int // depth of <a> elements containing the current token
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return s, err
+			}
+			return b.String(), nil
+		case html.TextToken:
+			text := string(z.Raw())
+			if links > 0 {
+				text = ur.rewrite(text)
+			}
+			// Make sure that newlines are preserved.
+			b.WriteString(strings.ReplaceAll(text, "\n", "<br>"))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			raw := string(z.Raw()) // Raw's data is overwritten by Token
+			tok := z.Token()
+			if tok.DataAtom == atom.A && tt == html.StartTagToken {
+				links++
+			}
+			var src, link string // original src and link for resized images
+			if tok.DataAtom == atom.Img && size != imageSizeDefault {
+				for _, a := range tok.Attr {
+					if a.Namespace == "" && a.Key == "src" {
+						src = a.Val
+					}
+				}
+			}
+			changed := ur.rewriteAttrs(tok.Attr)
+			if src != "" {
+				tok.Attr, link = ur.resizeImage(tok.Attr, src, size)
+				changed = changed || link != ""
+			}
+			if link != "" && links == 0 {
+				fmt.Fprintf(&b, `<a href="%s">`, html.EscapeString(link))
+			}
+			if !changed {
+				b.WriteString(raw) // preserve the original markup if nothing changed
+			} else if tt == html.SelfClosingTagToken {
+				b.WriteString(strings.TrimSuffix(tok.String(), "/>") + " />")
+			} else {
+				b.WriteString(tok.String())
+			}
+			if link != "" && links == 0 {
+				b.WriteString("</a>")
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "a" && links > 0 {
+				links--
+			}
+			b.Write(z.Raw())
+		default:
+			b.Write(z.Raw())
+		}
+	}
+}
+
+// urlAttrs lists HTML attributes containing URLs that are rewritten by rewriteContent.
+var urlAttrs = map[string]bool{"href": true, "src": true, "srcset": true, "poster": true}
+
+// urlRewriter rewrites URLs within a tweet's content fetched from a Nitter instance.
+type urlRewriter struct {
+	loc    *url.URL       // may be nil
+	locRe  *regexp.Regexp // matches URLs served by loc's host; nil if loc is nil
+	target *rewriteTarget // where rewritten URLs point
+}
+
+func newURLRewriter(loc *url.URL, target *rewriteTarget) (*urlRewriter, error) {
+	ur := &urlRewriter{loc: loc, target: target.forLoc(loc)}
+	if loc != nil {
+		// Match both http:// and https:// since some instances seem to be configured
+		// to always use http:// for links.
+		var err error
+		if ur.locRe, err = regexp.Compile(`\bhttps?://` + regexp.QuoteMeta(loc.Host) + `/[^" ]*`); err != nil {
+			return nil, err
+		}
+	}
+	return ur, nil
+}
+
+// rewrite rewrites all URLs within s.
+func (ur *urlRewriter) rewrite(s string) string {
+	return ur.target.mapAll(ur.canonical(s))
+}
+
+// canonical rewrites all URLs within s to point at twitter.com and *.twimg.com
+// without mapping them to ur.target.
+func (ur *urlRewriter) canonical(s string) string {
 	for _, rw := range rewritePatterns {
 		s = rw.re.ReplaceAllStringFunc(s, func(o string) string {
 			return rw.fn(rw.re.FindStringSubmatch(o))
 		})
 	}
-
 	// Match all remaining URLs served by the instance and change them to use twitter.com:
 	// https://github.com/derat/nitter-rss-proxy/issues/13
-	if loc != nil {
-		// Match both http:// and https:// since some instances seem to be configured
-		// to always use http:// for links.
-		re, err := regexp.Compile(`\bhttps?://` + regexp.QuoteMeta(loc.Host) + `/[^" ]*`)
-		if err != nil {
-			return s, err
-		}
-		s = re.ReplaceAllStringFunc(s, func(o string) string { return rewriteTwitterURL(o) })
+	if ur.locRe != nil {
+		s = ur.locRe.ReplaceAllStringFunc(s, func(o string) string { return rewriteTwitterURL(o) })
 	}
+	return s
+}
 
-	// TODO: Fetch embedded tweets.
+// rewriteURL rewrites a single URL from an attribute.
+func (ur *urlRewriter) rewriteURL(s string) string {
+	return ur.target.mapAll(ur.canonicalURL(s))
+}
 
-	// Make sure that newlines are preserved.
-	s = strings.ReplaceAll(s, "\n", "<br>")
+// canonicalURL is like canonical, but for a single URL from an attribute.
+// Paths relative to the instance are resolved against ur.loc first.
+func (ur *urlRewriter) canonicalURL(s string) string {
+	if ur.loc != nil && strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") {
+		if ref, err := url.Parse(s); err == nil {
+			s = ur.loc.ResolveReference(ref).String()
+		}
+	}
+	return ur.canonical(s)
+}
 
-	return s, nil
+// rewriteAttrs rewrites URLs in attrs in-place, returning true if any were changed.
+func (ur *urlRewriter) rewriteAttrs(attrs []html.Attribute) bool {
+	var changed bool
+	for i, a := range attrs {
+		if a.Namespace != "" || !urlAttrs[a.Key] {
+			continue
+		}
+		var val string
+		if a.Key == "srcset" {
+			// srcset contains comma-separated candidates, e.g. "a.jpg 1x, b.jpg 2x".
+			cands := strings.Split(a.Val, ",")
+			for j, c := range cands {
+				c = strings.TrimSpace(c)
+				u, desc := c, ""
+				if k := strings.IndexAny(c, " \t\n"); k >= 0 {
+					u, desc = c[:k], c[k:]
+				}
+				cands[j] = ur.rewriteURL(u) + desc
+			}
+			val = strings.Join(cands, ", ")
+		} else {
+			val = ur.rewriteURL(a.Val)
+		}
+		if val != a.Val {
+			attrs[i].Val = val
+			changed = true
+		}
+	}
+	return changed
 }
 
 // rewriteTwitterURL rewrites orig's scheme and hostname to be https://twitter.com.
@@ -539,6 +1570,17 @@ func rewriteTwitterURL(orig string) string {
 	return u.String()
 }
 
+// statusWriter is an http.ResponseWriter that records the response's status code.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	w.status = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
 // fakeResponseWriter is an http.ResponseWriter implementation that just writes to stdout.
 // It's used for the -user flag.
 type fakeResponseWriter struct {
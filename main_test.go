@@ -4,8 +4,14 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
+
+	"github.com/gorilla/feeds"
 )
 
 func TestRewriteContent(t *testing.T) {
@@ -49,12 +55,42 @@ func TestRewriteContent(t *testing.T) {
 			`The CST-100 <a href="http://nitter.kylrth.com/search?q=%23Starliner">#Starliner</a> flight`,
 			`The CST-100 <a href="https://twitter.com/search?q=%23Starliner">#Starliner</a> flight`,
 		},
+		{
+			// Attributes can appear in any order.
+			`https://nitter.net/user/status/123`,
+			`<img style="max-width:250px;" alt="x" src="https://nitter.net/pic/media%2FArpx24jXoAUzkc9.jpg">`,
+			`<img style="max-width:250px;" alt="x" src="https://pbs.twimg.com/media/Arpx24jXoAUzkc9?format=jpg">`,
+		},
+		{
+			// Text outside of links shouldn't be rewritten.
+			`https://nitter.net/user/status/123`,
+			"See nitter.net/foo/status/12345\nfor details",
+			"See nitter.net/foo/status/12345<br>for details",
+		},
+		{
+			// Newlines in attributes shouldn't be converted to <br>.
+			`https://nitter.net/user/status/123`,
+			"<a href=\"https://nitter.net/foo\" title=\"a\nb\">@foo</a>",
+			"<a href=\"https://twitter.com/foo\" title=\"a\nb\">@foo</a>",
+		},
+		{
+			`https://nitter.net/user/status/123`,
+			`<video poster="https://nitter.net/pic/tweet_video_thumb%2FA47B3e5XMAM233z.jpg">` +
+				`<source src="/pic/video.twimg.com%2Ftweet_video%2FA47B3e5XMAM233z.mp4"></video>`,
+			`<video poster="https://video.twimg.com/tweet_video_thumb/A47B3e5XMAM233z.jpg">` +
+				`<source src="https://video.twimg.com/tweet_video/A47B3e5XMAM233z.mp4"></video>`,
+		},
+		{
+			`https://nitter.net/user/status/123`,
+			`<img srcset="https://nitter.net/pic/media%2FAbc.jpg 1x, https://nitter.net/pic/media%2FDef.png 2x" />`,
+			`<img srcset="https://pbs.twimg.com/media/Abc?format=jpg 1x, https://pbs.twimg.com/media/Def?format=png 2x" />`,
+		},
 		// TODO: Add more tests if I feel like it.
 	} {
 		loc, err := url.Parse(tc.loc)
 		if err != nil {
 			t.Error("Failed parsing location:", err)
-		} else if got, err := rewriteContent(tc.orig, loc); err != nil {
+		} else if got, err := rewriteContent(tc.orig, loc, nil, imageSizeDefault); err != nil {
 			t.Errorf("rewriteContent(%q, %q) failed: %v", tc.orig, tc.loc, err)
 		} else if got != tc.want {
 			t.Errorf("rewriteContent(%q, %q) = %q; want %q", tc.orig, tc.loc, got, tc.want)
@@ -84,3 +120,258 @@ func TestRewriteIconURL(t *testing.T) {
 		}
 	}
 }
+
+func TestRewriteMediaURL(t *testing.T) {
+	for _, tc := range []struct {
+		orig, want string
+	}{
+		{
+			`https://example.org/pic/enc/bWVkaWEvRm1OMzlDZ1dRQUVrTkFPLmpwZw==`,
+			`https://pbs.twimg.com/media/FmN39CgWQAEkNAO?format=jpg`,
+		},
+		{
+			`https://example.org/pic/orig/enc/bWVkaWEvRm1OMzlDZ1dRQUVrTkFPLmpwZw==`,
+			`https://pbs.twimg.com/media/FmN39CgWQAEkNAO?format=jpg&name=orig`,
+		},
+		{
+			`https://example.org/pic/orig/media%2FFmN39CgWQAEkNAO.png`,
+			`https://pbs.twimg.com/media/FmN39CgWQAEkNAO?format=png&name=orig`,
+		},
+		{
+			`https://example.org/pic/pbs.twimg.com%2Fmedia%2FFmN39CgWQAEkNAO.jpg`,
+			`https://pbs.twimg.com/media/FmN39CgWQAEkNAO?format=jpg`,
+		},
+		{
+			`https://example.org/video/enc/4F3B2A/aHR0cHM6Ly92aWRlby50d2ltZy5jb20vdHdlZXRfdmlkZW8vQTQ3QjNlNVhNQU0yMzN6Lm1wNA==`,
+			`https://video.twimg.com/tweet_video/A47B3e5XMAM233z.mp4`,
+		},
+		{
+			`https://example.org/video/enc/4F3B2A/aHR0cHM6Ly92aWRlby50d2ltZy5jb20vZXh0X3R3X3ZpZGVvLzEyMzQ1Njc4OTAvcHUvcGwvQWJDLWRFX2YubTN1OD90YWc9MTImY29udGFpbmVyPWZtcDQ=`,
+			`https://video.twimg.com/ext_tw_video/1234567890/pu/pl/AbC-dE_f.m3u8?tag=12&container=fmp4`,
+		},
+		{
+			`https://example.org/video/4F3B2A/https%3A%2F%2Fvideo.twimg.com%2Fext_tw_video%2F1234567890%2Fpu%2Fpl%2FAbC-dE_f.m3u8%3Ftag%3D12`,
+			`https://video.twimg.com/ext_tw_video/1234567890/pu/pl/AbC-dE_f.m3u8?tag=12`,
+		},
+		{
+			// Videos that aren't hosted by Twitter are left alone.
+			`https://example.org/video/enc/4F3B2A/aHR0cHM6Ly9ldmlsLmV4YW1wbGUub3JnL3gubXA0`,
+			`https://example.org/video/enc/4F3B2A/aHR0cHM6Ly9ldmlsLmV4YW1wbGUub3JnL3gubXA0`,
+		},
+	} {
+		ur, err := newURLRewriter(nil, nil)
+		if err != nil {
+			t.Fatal("newURLRewriter failed:", err)
+		}
+		if got := ur.rewrite(tc.orig); got != tc.want {
+			t.Errorf("rewrite(%q) = %q; want %q", tc.orig, got, tc.want)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	const etag = `"abc123"`
+	mod := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	for _, tc := range []struct {
+		inm, ims string // If-None-Match and If-Modified-Since headers
+		want     bool
+	}{
+		{"", "", false},
+		{etag, "", true},
+		{`W/"abc123"`, "", true},
+		{`"foo", "abc123"`, "", true},
+		{"*", "", true},
+		{`"foo"`, "", false},
+		{`"foo"`, mod.Format(http.TimeFormat), false}, // If-None-Match takes precedence
+		{"", mod.Format(http.TimeFormat), true},
+		{"", mod.Add(time.Hour).Format(http.TimeFormat), true},
+		{"", mod.Add(-time.Second).Format(http.TimeFormat), false},
+		{"", "bogus", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+		if tc.inm != "" {
+			req.Header.Set("If-None-Match", tc.inm)
+		}
+		if tc.ims != "" {
+			req.Header.Set("If-Modified-Since", tc.ims)
+		}
+		if got := notModified(req, etag, mod); got != tc.want {
+			t.Errorf("notModified(%q, %q) = %v; want %v", tc.inm, tc.ims, got, tc.want)
+		}
+	}
+}
+
+func TestFeedValidators(t *testing.T) {
+	created := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	newFeed := func() *feeds.Feed {
+		return &feeds.Feed{
+			Title: "Feed",
+			Link:  &feeds.Link{Href: "https://twitter.com/user"},
+			Items: []*feeds.Item{{
+				Id:      "1",
+				Title:   "Hello",
+				Link:    &feeds.Link{Href: "https://twitter.com/user/status/1"},
+				Author:  &feeds.Author{Name: "@user"},
+				Content: "Hello",
+				Created: created,
+			}},
+		}
+	}
+	etag, mod := feedValidators(newFeed(), atomFormat)
+	if !mod.Equal(created) {
+		t.Errorf("feedValidators returned mod %v; want %v", mod, created)
+	}
+
+	for _, tc := range []struct {
+		desc   string
+		modify func(f *feeds.Feed)
+	}{
+		{"feed title", func(f *feeds.Feed) { f.Title = "Renamed" }},
+		{"feed link", func(f *feeds.Feed) { f.Link.Href = "https://x.com/user" }},
+		{"feed image", func(f *feeds.Feed) { f.Image = &feeds.Image{Url: "https://example.org/a.jpg"} }},
+		{"item title", func(f *feeds.Feed) { f.Items[0].Title = "RT @other: Hello" }},
+		{"item link", func(f *feeds.Feed) { f.Items[0].Link.Href = "https://x.com/user/status/1" }},
+		{"item author", func(f *feeds.Feed) { f.Items[0].Author.Name = "@other" }},
+		{"item content", func(f *feeds.Feed) { f.Items[0].Content = "Hello<hr />More" }},
+		{"item updated", func(f *feeds.Feed) { f.Items[0].Updated = created.Add(time.Minute) }},
+	} {
+		f := newFeed()
+		tc.modify(f)
+		if got, _ := feedValidators(f, atomFormat); got == etag {
+			t.Errorf("Changing %v didn't change ETag %v", tc.desc, etag)
+		}
+	}
+	if got, _ := feedValidators(newFeed(), jsonFormat); got == etag {
+		t.Errorf("Changing format didn't change ETag %v", etag)
+	}
+}
+
+func TestRequestFormat(t *testing.T) {
+	for _, tc := range []struct {
+		path   string
+		accept string
+		want   feedFormat
+	}{
+		{"/user", "", atomFormat},
+		{"/user?format=json", "", jsonFormat},
+		{"/user?format=RSS", "", rssFormat},
+		{"/user?max_position=123&format=rss", "", rssFormat},
+		{"/user.json", "", jsonFormat},
+		{"/user/media.rss", "", rssFormat},
+		{"/user.json?format=rss", "", rssFormat},
+		{"/user", "application/rss+xml", rssFormat},
+		{"/user", "application/feed+json", jsonFormat},
+		{"/user", "text/html, */*", atomFormat},
+		{"/user", "application/atom+xml;q=0.5, application/rss+xml;q=0.9", rssFormat},
+		{"/user", "application/rss+xml;q=0.5, application/json", jsonFormat},
+		{"/user.rss", "application/json", rssFormat},
+		// The default format should win ties.
+		{"/user", "application/rss+xml, application/atom+xml", atomFormat},
+		{"/user", "application/rss+xml, application/json", rssFormat},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		ms := userRegexp.FindStringSubmatch(req.URL.Path)
+		if ms == nil {
+			t.Errorf("userRegexp didn't match %q", req.URL.Path)
+			continue
+		}
+		if got, err := requestFormat(req, ms[2], atomFormat); err != nil {
+			t.Errorf("requestFormat(%q, %q) failed: %v", tc.path, tc.accept, err)
+		} else if got != tc.want {
+			t.Errorf("requestFormat(%q, %q) = %q; want %q", tc.path, tc.accept, got, tc.want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/user?format=bogus", nil)
+	if got, err := requestFormat(req, "", atomFormat); err == nil {
+		t.Errorf("requestFormat(%q) = %q; want error", req.URL, got)
+	}
+}
+
+func TestForwardedQuery(t *testing.T) {
+	for _, tc := range []struct{ raw, want string }{
+		{"", ""},
+		{"max_position=123", "max_position=123"},
+		{"format=json&max_position=123", "max_position=123"},
+		{"format=json", ""},
+		{"max_position=", ""},
+	} {
+		if got := forwardedQuery(tc.raw); got != tc.want {
+			t.Errorf("forwardedQuery(%q) = %q; want %q", tc.raw, got, tc.want)
+		}
+	}
+}
+
+// newSlowServer returns a server that doesn't respond until the request is canceled.
+func newSlowServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+}
+
+func TestFetchHedged(t *testing.T) {
+	slow := newSlowServer()
+	defer slow.Close()
+	fast := newFakeNitter(map[string]string{
+		"/someuser/rss": rssItem("someuser", 1, "Mon, 01 Jan 2024 10:00:00 GMT"),
+	})
+	defer fast.Close()
+
+	hnd, err := newHandler("", slow.URL+","+fast.URL, handlerOptions{
+		format:          atomFormat,
+		hedgeDelay:      10 * time.Millisecond,
+		circuitFailures: 1,
+	})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+	ff, err := hnd.fetchAny(context.Background(), "someuser", "")
+	if err != nil {
+		t.Fatal("fetchAny failed:", err)
+	}
+	if got := ff.instance.String(); got != fast.URL {
+		t.Errorf("fetchAny used %v; want %v", got, fast.URL)
+	}
+
+	// The canceled fetch from the slow instance shouldn't count as a failure.
+	time.Sleep(10 * time.Millisecond)
+	for _, st := range hnd.health.status(hnd.instances, time.Now()) {
+		if st.Failures != 0 {
+			t.Errorf("%v has %v failure(s)", st.URL, st.Failures)
+		}
+	}
+}
+
+func TestServeHTTP_Deadline(t *testing.T) {
+	slow := newSlowServer()
+	defer slow.Close()
+
+	hnd, err := newHandler("", slow.URL, handlerOptions{
+		format:          atomFormat,
+		timeout:         10 * time.Second,
+		deadline:        50 * time.Millisecond,
+		circuitFailures: 1,
+	})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+	w := httptest.NewRecorder()
+	start := time.Now()
+	hnd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/someuser", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("ServeHTTP returned %v; want %v", w.Code, http.StatusGatewayTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ServeHTTP took %v", elapsed)
+	}
+	// The instance shouldn't be penalized for the handler giving up.
+	if st := hnd.health.status(hnd.instances, time.Now()); st[0].Failures != 0 {
+		t.Errorf("%v has %v failure(s)", st[0].URL, st[0].Failures)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mediaPrefix = "/media/"          // path prefix for the media endpoint
+	mediaMaxAge = 7 * 24 * time.Hour // max-age sent with proxied media, which doesn't change
+)
+
+// mediaOrigins maps hosts that may be proxied by the media endpoint to their origin URLs.
+var mediaOrigins = map[string]string{
+	"pbs.twimg.com":   "https://pbs.twimg.com",
+	"video.twimg.com": "https://video.twimg.com",
+}
+
+// mediaRequestHeaders lists request headers that are forwarded when fetching media.
+var mediaRequestHeaders = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+
+// mediaResponseHeaders lists response headers that are copied from fetched media.
+var mediaResponseHeaders = []string{
+	"Accept-Ranges", "Content-Length", "Content-Range", "Content-Type", "ETag", "Last-Modified",
+}
+
+// mediaBase returns the URL of the media endpoint, e.g. "https://proxy.example.org/media".
+func (hnd *handler) mediaBase() *url.URL {
+	u := *hnd.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + strings.TrimSuffix(mediaPrefix, "/")
+	u.RawPath, u.RawQuery, u.Fragment = "", "", ""
+	return &u
+}
+
+// mediaSources returns the URLs from which the media at p (relative to the media endpoint,
+// e.g. "pbs.twimg.com/media/AbC.jpg") can be fetched: first its origin, and then the
+// corresponding /pic/ URLs on each Nitter instance. nil is returned if p's host isn't proxied.
+func (hnd *handler) mediaSources(p, query string) []string {
+	i := strings.IndexByte(p, '/')
+	if i < 0 {
+		return nil
+	}
+	origin, ok := mediaOrigins[p[:i]]
+	if !ok {
+		return nil
+	}
+	u, err := url.Parse(origin + p[i:])
+	if err != nil {
+		return nil
+	}
+	u.RawQuery = query
+	srcs := []string{u.String()}
+	twimg := "https://" + p[:i] + u.EscapedPath()
+	if query != "" {
+		twimg += "?" + query
+	}
+	for _, in := range hnd.instances {
+		srcs = append(srcs, (&rewriteTarget{nitter: in}).mapURL(twimg))
+	}
+	return srcs
+}
+
+// mediaType returns true if ct (a Content-Type header) describes proxyable media.
+func mediaType(ct string) bool {
+	ct = strings.ToLower(ct)
+	return strings.HasPrefix(ct, "image/") || strings.HasPrefix(ct, "video/") ||
+		strings.HasPrefix(ct, "application/x-mpegurl") || strings.HasPrefix(ct, "application/vnd.apple.mpegurl")
+}
+
+// serveMedia streams the media at p (relative to the media endpoint) to w.
+// Range and conditional requests are forwarded so that video can be seeked.
+func (hnd *handler) serveMedia(w http.ResponseWriter, req *http.Request, p string) {
+	srcs := hnd.mediaSources(p, req.URL.RawQuery)
+	if srcs == nil {
+		http.Error(w, "Invalid media", http.StatusNotFound)
+		return
+	}
+	for _, src := range srcs {
+		resp, err := hnd.fetchMedia(req, src)
+		if err != nil {
+			log.Printf("Failed fetching media %v: %v", src, err)
+			continue
+		}
+		defer resp.Body.Close()
+
+		if hnd.opts.mediaMaxSize > 0 && resp.ContentLength > hnd.opts.mediaMaxSize {
+			log.Printf("Media %v too large (%d bytes)", src, resp.ContentLength)
+			http.Error(w, "Media too large", http.StatusBadGateway)
+			return
+		}
+		body := io.Reader(resp.Body)
+		if hnd.opts.mediaMaxSize > 0 && resp.ContentLength < 0 {
+			// If the response didn't declare its length, buffer it (plus an extra byte to
+			// detect oversized media) so that it can be rejected before the status is sent.
+			b, err := ioutil.ReadAll(io.LimitReader(resp.Body, hnd.opts.mediaMaxSize+1))
+			if err != nil {
+				log.Printf("Failed reading media %v: %v", src, err)
+				continue
+			}
+			if int64(len(b)) > hnd.opts.mediaMaxSize {
+				log.Printf("Media %v too large (more than %d bytes)", src, hnd.opts.mediaMaxSize)
+				http.Error(w, "Media too large", http.StatusBadGateway)
+				return
+			}
+			body = bytes.NewReader(b)
+			resp.Header.Set("Content-Length", strconv.Itoa(len(b)))
+		}
+		for _, h := range mediaResponseHeaders {
+			if v := resp.Header.Get(h); v != "" {
+				w.Header().Set(h, v)
+			}
+		}
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(mediaMaxAge.Seconds())))
+		}
+		w.WriteHeader(resp.StatusCode)
+		if _, err := io.Copy(w, body); err != nil {
+			log.Printf("Failed copying media %v: %v", src, err)
+		}
+		return
+	}
+	http.Error(w, "Couldn't get media", http.StatusBadGateway)
+}
+
+// fetchMedia fetches src for req, forwarding relevant headers.
+// An error is returned if src didn't return usable media.
+func (hnd *handler) fetchMedia(req *http.Request, src string) (*http.Response, error) {
+	mreq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range mediaRequestHeaders {
+		if v := req.Header.Get(h); v != "" {
+			mreq.Header.Set(h, v)
+		}
+	}
+	// Don't use hnd.client, since its timeout would cut off long videos.
+	resp, err := http.DefaultClient.Do(mreq)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		if ct := resp.Header.Get("Content-Type"); !mediaType(ct) {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected type %q", ct)
+		}
+		return resp, nil
+	case http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+		return resp, nil
+	default:
+		resp.Body.Close()
+		return nil, &statusError{resp.StatusCode, resp.Status}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRewriteTarget_Media(t *testing.T) {
+	base, _ := url.Parse("https://proxy.example.org/feeds/media")
+	for _, tc := range []struct {
+		target *rewriteTarget
+		orig   string
+		want   string
+	}{
+		{nil, "https://pbs.twimg.com/media/AbC?format=jpg&name=small",
+			"https://proxy.example.org/feeds/media/pbs.twimg.com/media/AbC?format=jpg&name=small"},
+		{nil, "https://video.twimg.com/tweet_video/AbC.mp4",
+			"https://proxy.example.org/feeds/media/video.twimg.com/tweet_video/AbC.mp4"},
+		{nil, "https://twitter.com/someuser", "https://twitter.com/someuser"},
+		{&rewriteTarget{host: "x.com"}, "https://twitter.com/someuser", "https://x.com/someuser"},
+	} {
+		if got := tc.target.withMedia(base).mapURL(tc.orig); got != tc.want {
+			t.Errorf("mapURL(%q) = %q; want %q", tc.orig, got, tc.want)
+		}
+	}
+}
+
+// newMediaServer returns a server that serves data as a JPEG image at p with Range and
+// conditional request support.
+func newMediaServer(p string, data []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.EscapedPath() != p {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+	}))
+}
+
+func TestServeMedia(t *testing.T) {
+	data := []byte("0123456789")
+	origin := newMediaServer("/media/AbC.jpg", data)
+	defer origin.Close()
+	// The instance should only be used if the origin doesn't have the file.
+	inst := newMediaServer("/pic/media%2FDeF.jpg", data)
+	defer inst.Close()
+
+	old := mediaOrigins["pbs.twimg.com"]
+	mediaOrigins["pbs.twimg.com"] = origin.URL
+	defer func() { mediaOrigins["pbs.twimg.com"] = old }()
+
+	hnd, err := newHandler("https://proxy.example.org/", inst.URL, handlerOptions{
+		format: atomFormat, mediaProxy: true, mediaMaxSize: int64(len(data)),
+	})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+
+	for _, tc := range []struct {
+		path, rng string
+		code      int
+		body      string
+	}{
+		{"/media/pbs.twimg.com/media/AbC.jpg", "", http.StatusOK, string(data)},
+		{"/media/pbs.twimg.com/media/AbC.jpg", "bytes=2-4", http.StatusPartialContent, "234"},
+		{"/media/pbs.twimg.com/media/DeF.jpg", "", http.StatusOK, string(data)},
+		{"/media/pbs.twimg.com/media/Missing.jpg", "", http.StatusBadGateway, ""},
+		{"/media/example.org/foo.jpg", "", http.StatusNotFound, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.rng != "" {
+			req.Header.Set("Range", tc.rng)
+		}
+		w := httptest.NewRecorder()
+		hnd.ServeHTTP(w, req)
+		if w.Code != tc.code {
+			t.Errorf("%v (%q) returned %v; want %v", tc.path, tc.rng, w.Code, tc.code)
+		} else if tc.body != "" && w.Body.String() != tc.body {
+			t.Errorf("%v (%q) returned %q; want %q", tc.path, tc.rng, w.Body.String(), tc.body)
+		} else if cc, ok := w.Header().Get("Cache-Control"), tc.code == http.StatusOK ||
+			tc.code == http.StatusPartialContent; ok != strings.HasPrefix(cc, "public") {
+			t.Errorf("%v (%q) has Cache-Control %q", tc.path, tc.rng, cc)
+		}
+	}
+
+	// Cache-Control shouldn't be sent for responses without media.
+	req := httptest.NewRequest(http.MethodGet, "/media/pbs.twimg.com/media/AbC.jpg", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	w := httptest.NewRecorder()
+	hnd.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Conditional request returned %v; want %v", w.Code, http.StatusNotModified)
+	} else if cc := w.Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("Conditional request returned Cache-Control %q", cc)
+	}
+
+	// Media over the size limit should be rejected.
+	hnd.opts.mediaMaxSize = int64(len(data) - 1)
+	w = httptest.NewRecorder()
+	hnd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/media/pbs.twimg.com/media/AbC.jpg", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Oversized media returned %v; want %v", w.Code, http.StatusBadGateway)
+	}
+
+	if _, err := newHandler("", inst.URL, handlerOptions{mediaProxy: true}); err == nil {
+		t.Error("newHandler unexpectedly succeeded for media proxy without base URL")
+	}
+}
+
+func TestServeMedia_Unsized(t *testing.T) {
+	// Write the media in chunks without declaring its length.
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		for i := 0; i < 4; i++ {
+			io.WriteString(w, "01234")
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer origin.Close()
+
+	old := mediaOrigins["pbs.twimg.com"]
+	mediaOrigins["pbs.twimg.com"] = origin.URL
+	defer func() { mediaOrigins["pbs.twimg.com"] = old }()
+
+	hnd, err := newHandler("https://proxy.example.org/", origin.URL, handlerOptions{
+		format: atomFormat, mediaProxy: true, mediaMaxSize: 20,
+	})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		hnd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/media/pbs.twimg.com/media/AbC.jpg", nil))
+		return w
+	}
+	if w := get(); w.Code != http.StatusOK || w.Body.Len() != 20 {
+		t.Errorf("Fetching media at size limit returned %v with %d byte(s); want %v with 20",
+			w.Code, w.Body.Len(), http.StatusOK)
+	} else if cl := w.Header().Get("Content-Length"); cl != "20" {
+		t.Errorf("Fetching media at size limit returned Content-Length %q; want %q", cl, "20")
+	}
+
+	// Oversized media should be rejected rather than truncated.
+	hnd.opts.mediaMaxSize = 19
+	if w := get(); w.Code != http.StatusBadGateway {
+		t.Errorf("Fetching oversized media returned %v with %d byte(s); want %v",
+			w.Code, w.Body.Len(), http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/feeds"
+)
+
+// splitUsers splits a comma-separated user path into individual paths,
+// e.g. ["foo/media", "bar/media"] for "foo,bar/media". Empty and duplicate users are dropped.
+func splitUsers(user string) []string {
+	var suffix string
+	if i := strings.IndexByte(user, '/'); i >= 0 {
+		user, suffix = user[:i], user[i:]
+	}
+	var users []string
+	seen := make(map[string]bool)
+	for _, u := range strings.Split(user, ",") {
+		if u == "" || seen[strings.ToLower(u)] {
+			continue
+		}
+		seen[strings.ToLower(u)] = true
+		users = append(users, u+suffix)
+	}
+	return users
+}
+
+// mergeFeeds gets the feeds for users (e.g. "foo" or "foo/media") in parallel and merges
+// their items into a single feed named name, newest first. Items are de-duplicated by tweet ID
+// and capped at hnd.opts.mergeMax. At most hnd.opts.mergeParallel feeds are fetched at once.
+// Partial results are returned if some users' feeds couldn't be fetched. The instances that
+// served the feeds are saved to rec.
+func (hnd *handler) mergeFeeds(ctx context.Context, users []string, name, query string,
+	fo feedOptions, rec *requestRecord) (*feeds.Feed, error) {
+	type result struct {
+		ff     *fetchedFeed
+		cached bool
+		feed   *feeds.Feed
+		err    error
+	}
+	results := make([]result, len(users))
+	var sem chan struct{} // limits concurrent fetches; nil if unlimited
+	if hnd.opts.mergeParallel > 0 {
+		sem = make(chan struct{}, hnd.opts.mergeParallel)
+	}
+	var wg sync.WaitGroup
+	for i, u := range users {
+		wg.Add(1)
+		go func(u string, res *result) {
+			defer wg.Done()
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+					defer func() { <-sem }()
+				case <-ctx.Done():
+					res.err = ctx.Err()
+					return
+				}
+			}
+			if res.ff, res.cached, res.err = hnd.getFeed(ctx, fo.fetchPath(u), query); res.err == nil {
+				res.feed, res.err = hnd.rewrite(ctx, res.ff.feed, u, res.ff.loc, fo)
+			}
+		}(u, &results[i])
+	}
+	wg.Wait()
+
+	var names []string
+	seenNames := make(map[string]bool)
+	for _, u := range users {
+		if n := "@" + feedUsers(u)[0]; !seenNames[n] {
+			names = append(names, n)
+			seenNames[n] = true
+		}
+	}
+	feed := &feeds.Feed{
+		Title:       "Tweets from " + strings.Join(names, ", "),
+		Link:        &feeds.Link{Href: fo.target.mapURL(searchURL(names))},
+		Description: "Twitter feed for " + name,
+	}
+	if fo.title != "" {
+		feed.Title = fo.title
+	}
+
+	var instances []string
+	var failed int
+	seenIDs := make(map[string]bool)
+	seenInsts := make(map[string]bool)
+	rec.Cached = true
+	for i, res := range results {
+		if res.err != nil {
+			log.Printf("Failed getting %v for %v: %v", users[i], name, res.err)
+			failed++
+			continue
+		}
+		rec.Cached = rec.Cached && res.cached
+		if in := res.ff.instance; in != nil && !seenInsts[in.String()] {
+			instances = append(instances, in.String())
+			seenInsts[in.String()] = true
+		}
+		if res.feed.Updated.After(feed.Updated) {
+			feed.Updated = res.feed.Updated
+		}
+		for _, item := range res.feed.Items {
+			id := tweetID(item.Id)
+			if id == "" {
+				id = item.Id
+			}
+			if !seenIDs[id] {
+				seenIDs[id] = true
+				feed.Items = append(feed.Items, item)
+			}
+		}
+	}
+	if failed == len(users) {
+		rec.Cached = false
+		return nil, errors.New("all users failed")
+	}
+	rec.Instance = strings.Join(instances, ",")
+
+	sort.SliceStable(feed.Items, func(i, j int) bool {
+		return feed.Items[i].Created.After(feed.Items[j].Created)
+	})
+	if max := hnd.opts.mergeMax; max > 0 && len(feed.Items) > max {
+		feed.Items = feed.Items[:max]
+	}
+	return feed, nil
+}
+
+// searchURL returns the URL of a Twitter search for tweets from the supplied users,
+// e.g. "@foo" and "@bar".
+func searchURL(users []string) string {
+	terms := make([]string, len(users))
+	for i, u := range users {
+		terms[i] = "from:" + strings.TrimPrefix(u, "@")
+	}
+	return "https://twitter.com/search?f=live&q=" + url.QueryEscape(strings.Join(terms, " OR "))
+}
@@ -0,0 +1,188 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSplitUsers(t *testing.T) {
+	for _, tc := range []struct {
+		user string
+		want []string
+	}{
+		{"foo", []string{"foo"}},
+		{"foo,bar", []string{"foo", "bar"}},
+		{"foo,Bar,,bar/media", []string{"foo/media", "Bar/media"}},
+	} {
+		if got := splitUsers(tc.user); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitUsers(%q) = %q; want %q", tc.user, got, tc.want)
+		}
+	}
+}
+
+// rssItem returns an RSS item for the supplied tweet.
+func rssItem(author string, id int, date string) string {
+	return fmt.Sprintf(`<item><title>Tweet %d</title><dc:creator>@%s</dc:creator>`+
+		`<description>Tweet %d</description><pubDate>%s</pubDate>`+
+		`<guid>https://nitter.example.org/%s/status/%d#m</guid>`+
+		`<link>https://nitter.example.org/%s/status/%d#m</link></item>`,
+		id, author, id, date, author, id, author, id)
+}
+
+// newFakeNitter returns a server that serves RSS feeds containing the items in feeds,
+// keyed by path (e.g. "/someuser/rss").
+func newFakeNitter(feeds map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		items, ok := feeds[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+			`<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/"><channel>`+
+			`<title>Feed</title><link>https://nitter.example.org/</link>`+items+`</channel></rss>`)
+	}))
+}
+
+func TestMergeFeeds(t *testing.T) {
+	srv := newFakeNitter(map[string]string{
+		"/foo/rss": rssItem("foo", 1, "Mon, 01 Jan 2024 10:00:00 GMT") +
+			rssItem("foo", 3, "Mon, 01 Jan 2024 12:00:00 GMT"),
+		// bar retweeted one of foo's tweets.
+		"/bar/rss": rssItem("bar", 2, "Mon, 01 Jan 2024 11:00:00 GMT") +
+			rssItem("foo", 3, "Mon, 01 Jan 2024 12:00:00 GMT") +
+			rssItem("bar", 4, "Mon, 01 Jan 2024 13:00:00 GMT"),
+	})
+	defer srv.Close()
+
+	hnd, err := newHandler("", srv.URL, handlerOptions{format: atomFormat, merge: true, mergeMax: 3})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+
+	var rec requestRecord
+	feed, err := hnd.mergeFeeds(context.Background(), []string{"foo", "bar", "missing"}, "foo,bar,missing", "",
+		hnd.optionsFor("foo,bar,missing"), &rec)
+	if err != nil {
+		t.Fatal("mergeFeeds failed:", err)
+	}
+	var got []string
+	for _, it := range feed.Items {
+		got = append(got, it.Title)
+	}
+	if want := []string{"Tweet 4", "Tweet 3", "Tweet 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeFeeds returned %q; want %q", got, want)
+	}
+	if rec.Instance != srv.URL {
+		t.Errorf("mergeFeeds recorded instance %q; want %q", rec.Instance, srv.URL)
+	}
+	if !strings.Contains(feed.Link.Href, "from%3Afoo") {
+		t.Errorf("mergeFeeds returned link %q", feed.Link.Href)
+	}
+
+	if _, err := hnd.mergeFeeds(context.Background(), []string{"missing", "missing2"}, "missing,missing2", "",
+		hnd.optionsFor("missing"), &rec); err == nil {
+		t.Error("mergeFeeds unexpectedly succeeded for missing users")
+	}
+}
+
+func TestServeBundle(t *testing.T) {
+	srv := newFakeNitter(map[string]string{
+		"/foo/rss":       rssItem("foo", 1, "Mon, 01 Jan 2024 10:00:00 GMT"),
+		"/bar/media/rss": rssItem("bar", 2, "Mon, 01 Jan 2024 11:00:00 GMT"),
+	})
+	defer srv.Close()
+
+	hnd, err := newHandler("https://proxy.example.org/", srv.URL, handlerOptions{
+		format: atomFormat,
+		bundles: map[string]*bundleConfig{
+			"pals": {Users: []string{"foo", "bar/media"}, feedConfig: feedConfig{Title: "My Pals"}},
+		},
+	})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+
+	w := httptest.NewRecorder()
+	hnd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bundle/pals.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/bundle/pals.json returned %v: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	for _, s := range []string{"My Pals", "Tweet 1", "Tweet 2", "https://proxy.example.org/bundle/pals.json"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("/bundle/pals.json doesn't contain %q:\n%s", s, body)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	hnd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bundle/bogus", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/bundle/bogus returned %v; want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestServeHTTP_NoUsers(t *testing.T) {
+	hnd, err := newHandler("", "https://nitter.example.org", handlerOptions{format: atomFormat, merge: true})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+	for _, p := range []string{"/,", "/,,", "/,/media"} {
+		w := httptest.NewRecorder()
+		hnd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v returned %v; want %v", p, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestMergeFeeds_Parallel(t *testing.T) {
+	const parallel = 2
+	var mu sync.Mutex
+	var active, maxActive int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		user := strings.Split(req.URL.Path, "/")[1]
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+			`<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/"><channel>`+
+			`<title>Feed</title><link>https://nitter.example.org/</link>`+
+			rssItem(user, len(user), "Mon, 01 Jan 2024 10:00:00 GMT")+`</channel></rss>`)
+	}))
+	defer srv.Close()
+
+	hnd, err := newHandler("", srv.URL, handlerOptions{format: atomFormat, merge: true, mergeParallel: parallel})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+	users := []string{"a", "bb", "ccc", "dddd", "eeeee"}
+	var rec requestRecord
+	feed, err := hnd.mergeFeeds(context.Background(), users, "merged", "", hnd.optionsFor("merged"), &rec)
+	if err != nil {
+		t.Fatal("mergeFeeds failed:", err)
+	}
+	if len(feed.Items) != len(users) {
+		t.Errorf("Got %d item(s); want %d", len(feed.Items), len(users))
+	}
+	if maxActive > parallel {
+		t.Errorf("Got %d concurrent fetches; want at most %d", maxActive, parallel)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsPrefix = "nitter_rss_proxy_"
+
+// latencyBuckets contains upper bounds in seconds for fetch latency histograms.
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metrics contains Prometheus-style metrics describing the handler's activity.
+type metrics struct {
+	requests       *counterVec   // requests by status and format
+	fetches        *counterVec   // fetch attempts by instance
+	fetchFailures  *counterVec   // failed fetches by instance
+	fetchDuration  *histogramVec // fetch latency by instance
+	itemsRewritten *counterVec   // items rewritten by feed
+	cacheHits      *counterVec   // cache lookups that returned a usable feed
+	cacheMisses    *counterVec   // cache lookups that required fetching
+	foreignItems   *counterVec   // items by unexpected authors by feed
+	rejectedFeeds  *counterVec   // fetched feeds rejected for containing foreign items by instance
+
+	all []metricWriter // all of the above, in the order in which they're written
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		requests: newCounterVec("requests_total",
+			"Feed requests by HTTP status and feed format.", "status", "format"),
+		fetches: newCounterVec("fetches_total",
+			"Feed fetch attempts by Nitter instance.", "instance"),
+		fetchFailures: newCounterVec("fetch_failures_total",
+			"Failed feed fetches by Nitter instance.", "instance"),
+		fetchDuration: newHistogramVec("fetch_duration_seconds",
+			"Feed fetch latency by Nitter instance.", latencyBuckets, "instance"),
+		itemsRewritten: newCounterVec("items_rewritten_total",
+			"Items rewritten by feed.", "feed"),
+		cacheHits: newCounterVec("cache_hits_total",
+			"Cache lookups that returned a fresh or stale feed."),
+		cacheMisses: newCounterVec("cache_misses_total",
+			"Cache lookups that required fetching a feed."),
+		foreignItems: newCounterVec("foreign_items_total",
+			"Items by authors other than the feed's user(s), excluding retweets.", "feed"),
+		rejectedFeeds: newCounterVec("rejected_feeds_total",
+			"Fetched feeds rejected for containing too many items from other users by Nitter instance.",
+			"instance"),
+	}
+	m.all = []metricWriter{m.requests, m.fetches, m.fetchFailures, m.fetchDuration,
+		m.itemsRewritten, m.cacheHits, m.cacheMisses, m.foreignItems, m.rejectedFeeds}
+	return m
+}
+
+// ServeHTTP writes all metrics to w in Prometheus's text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, mw := range m.all {
+		mw.write(w)
+	}
+}
+
+// metricWriter is implemented by types that can write themselves in the text exposition format.
+type metricWriter interface {
+	write(w io.Writer)
+}
+
+// labelSet holds the names of a metric's labels.
+type labelSet []string
+
+// key returns a map key for the supplied label values.
+func (ls labelSet) key(vals []string) string {
+	if len(vals) != len(ls) {
+		panic(fmt.Sprintf("got %d label value(s); want %d", len(vals), len(ls)))
+	}
+	return strings.Join(vals, "\x00")
+}
+
+// format formats the label values in key (as returned by ls.key) along with
+// any extra name/value pairs, e.g. `{instance="https://example.org",le="0.5"}`.
+func (ls labelSet) format(key string, extra ...string) string {
+	var parts []string
+	if len(ls) > 0 {
+		for i, v := range strings.Split(key, "\x00") {
+			parts = append(parts, fmt.Sprintf(`%s="%s"`, ls[i], labelEscaper.Replace(v)))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, extra[i], labelEscaper.Replace(extra[i+1])))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// labelEscaper escapes label values as described at
+// https://prometheus.io/docs/instrumenting/exposition_formats/#text-format-details.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// counterVec is a counter partitioned by label values.
+type counterVec struct {
+	name, help string
+	labels     labelSet
+
+	mu   sync.Mutex
+	vals map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: metricsPrefix + name, help: help, labels: labels,
+		vals: make(map[string]float64)}
+}
+
+// add adds v to the counter with the supplied label values.
+func (c *counterVec) add(v float64, labelVals ...string) {
+	key := c.labels.key(labelVals)
+	c.mu.Lock()
+	c.vals[key] += v
+	c.mu.Unlock()
+}
+
+// inc increments the counter with the supplied label values.
+func (c *counterVec) inc(labelVals ...string) { c.add(1, labelVals...) }
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 && len(c.vals) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, key := range sortedKeys(c.vals) {
+		fmt.Fprintf(w, "%s%s %v\n", c.name, c.labels.format(key), c.vals[key])
+	}
+}
+
+// histogramVec is a histogram partitioned by label values.
+type histogramVec struct {
+	name, help string
+	labels     labelSet
+	buckets    []float64 // upper bounds, in ascending order
+
+	mu     sync.Mutex
+	counts map[string][]uint64 // per-bucket (non-cumulative) counts, plus +Inf
+	sums   map[string]float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: metricsPrefix + name, help: help, labels: labels, buckets: buckets,
+		counts: make(map[string][]uint64), sums: make(map[string]float64)}
+}
+
+// observe records v in the histogram with the supplied label values.
+func (h *histogramVec) observe(v float64, labelVals ...string) {
+	key := h.labels.key(labelVals)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts := h.counts[key]
+	if counts == nil {
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[key] = counts
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	counts[i]++
+	h.sums[key] += v
+}
+
+// observeDuration records d in seconds.
+func (h *histogramVec) observeDuration(d time.Duration, labelVals ...string) {
+	h.observe(d.Seconds(), labelVals...)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.sums) {
+		var total uint64
+		for i, cnt := range h.counts[key] {
+			total += cnt
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = fmt.Sprint(h.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels.format(key, "le", le), total)
+		}
+		fmt.Fprintf(w, "%s_sum%s %v\n", h.name, h.labels.format(key), h.sums[key])
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels.format(key), total)
+	}
+}
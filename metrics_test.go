@@ -0,0 +1,49 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := newCounterVec("things_total", "Things by kind.", "kind")
+	c.inc("b")
+	c.add(2, `a"\`)
+	c.inc("b")
+
+	var sb strings.Builder
+	c.write(&sb)
+	want := `# HELP nitter_rss_proxy_things_total Things by kind.
+# TYPE nitter_rss_proxy_things_total counter
+nitter_rss_proxy_things_total{kind="a\"\\"} 2
+nitter_rss_proxy_things_total{kind="b"} 2
+`
+	if got := sb.String(); got != want {
+		t.Errorf("write produced:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := newHistogramVec("latency_seconds", "Latency.", []float64{1, 5}, "instance")
+	h.observe(0.5, "a")
+	h.observe(1, "a")
+	h.observe(3, "a")
+	h.observe(10, "a")
+
+	var sb strings.Builder
+	h.write(&sb)
+	want := `# HELP nitter_rss_proxy_latency_seconds Latency.
+# TYPE nitter_rss_proxy_latency_seconds histogram
+nitter_rss_proxy_latency_seconds_bucket{instance="a",le="1"} 2
+nitter_rss_proxy_latency_seconds_bucket{instance="a",le="5"} 3
+nitter_rss_proxy_latency_seconds_bucket{instance="a",le="+Inf"} 4
+nitter_rss_proxy_latency_seconds_sum{instance="a"} 14.5
+nitter_rss_proxy_latency_seconds_count{instance="a"} 4
+`
+	if got := sb.String(); got != want {
+		t.Errorf("write produced:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	quoteCacheSize = 1000             // max number of quoted tweets to cache
+	quoteTTL       = 24 * time.Hour   // time for which fetched quoted tweets are cached
+	quoteErrorTTL  = 10 * time.Minute // time for which failed fetches are cached
+)
+
+// quotedTweet describes a tweet quoted by another tweet.
+type quotedTweet struct {
+	url      string   // absolute URL of the tweet's Nitter status page
+	fullName string   // e.g. "Some User"
+	username string   // e.g. "@someuser"
+	content  string   // tweet's HTML content with absolute URLs
+	media    []string // absolute URLs of images and video thumbnails
+}
+
+// html returns a blockquote containing q for inclusion in a tweet's content.
+func (q *quotedTweet) html() string {
+	var b strings.Builder
+	b.WriteString("<blockquote>")
+	fmt.Fprintf(&b, `<p><a href="%s">`, html.EscapeString(q.url))
+	if q.fullName != "" {
+		fmt.Fprintf(&b, "%s (%s)", html.EscapeString(q.fullName), html.EscapeString(q.username))
+	} else {
+		b.WriteString(html.EscapeString(q.username))
+	}
+	b.WriteString("</a>:</p>")
+	if q.content != "" {
+		b.WriteString("<p>" + q.content + "</p>")
+	}
+	for _, m := range q.media {
+		fmt.Fprintf(&b, `<img src="%s" style="max-width:250px;" />`, html.EscapeString(m))
+	}
+	b.WriteString("</blockquote>")
+	return b.String()
+}
+
+// quoteEntry is an entry in quoteCache.
+type quoteEntry struct {
+	quote   *quotedTweet // nil if fetching failed
+	expires time.Time
+}
+
+// quoteCache caches quoted tweets keyed by tweet ID.
+// It's safe for concurrent use.
+type quoteCache struct {
+	mu      sync.Mutex
+	entries map[string]quoteEntry
+}
+
+func newQuoteCache() *quoteCache {
+	return &quoteCache{entries: make(map[string]quoteEntry)}
+}
+
+// get returns the cached tweet with the supplied ID.
+// ok is false if the tweet isn't cached. q is nil if fetching the tweet previously failed.
+func (qc *quoteCache) get(id string, now time.Time) (q *quotedTweet, ok bool) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	e, ok := qc.entries[id]
+	if !ok || !now.Before(e.expires) {
+		return nil, false
+	}
+	return e.quote, true
+}
+
+// set caches q (which may be nil to record a failure) as the tweet with the supplied ID.
+// The entry closest to expiring is evicted if the cache is full.
+func (qc *quoteCache) set(id string, q *quotedTweet, now time.Time) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	if _, ok := qc.entries[id]; !ok && len(qc.entries) >= quoteCacheSize {
+		var oldID string
+		var oldExp time.Time
+		for eid, e := range qc.entries {
+			if oldID == "" || e.expires.Before(oldExp) {
+				oldID, oldExp = eid, e.expires
+			}
+		}
+		delete(qc.entries, oldID)
+	}
+	ttl := quoteTTL
+	if q == nil {
+		ttl = quoteErrorTTL
+	}
+	qc.entries[id] = quoteEntry{q, now.Add(ttl)}
+}
+
+// quotedStatus returns the username and ID of the tweet quoted in content, a tweet's
+// HTML content. Nitter links to quoted tweets at the end of the content, so the last link
+// to a status other than ownID is used. Empty strings are returned if there's no such link.
+func quotedStatus(content, ownID string) (user, id string) {
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return user, id
+		case html.StartTagToken:
+			tok := z.Token()
+			if tok.DataAtom != atom.A {
+				continue
+			}
+			for _, a := range tok.Attr {
+				if a.Key != "href" {
+					continue
+				}
+				if lu, lid := linkUser(a.Val), tweetID(a.Val); lu != "" && lid != "" && lid != ownID {
+					user, id = lu, lid
+				}
+			}
+		}
+	}
+}
+
+// quoteBudget limits the number of quoted tweets fetched for a single requested feed,
+// including all of the users' feeds in a merged feed. It's safe for concurrent use.
+type quoteBudget struct {
+	mu sync.Mutex
+	n  int // remaining fetches
+}
+
+func newQuoteBudget(n int) *quoteBudget { return &quoteBudget{n: n} }
+
+// take returns true and decrements qb's remaining fetches if any remain.
+func (qb *quoteBudget) take() bool {
+	qb.mu.Lock()
+	defer qb.mu.Unlock()
+	if qb.n <= 0 {
+		return false
+	}
+	qb.n--
+	return true
+}
+
+// expandQuotes returns the contents of items with blockquotes containing the tweets that
+// they quote appended, keyed by item. Items that don't quote tweets (or whose quoted tweets
+// couldn't be fetched) are omitted. loc is the location from which items' feed was fetched;
+// quoted tweets are fetched in parallel from the same instance, taking at most hnd.opts.timeout
+// in total. Tweets that aren't cached are only fetched while budget has fetches remaining.
+func (hnd *handler) expandQuotes(ctx context.Context, items []*gofeed.Item, loc *url.URL,
+	budget *quoteBudget) map[*gofeed.Item]string {
+	if loc == nil {
+		return nil
+	}
+	type quote struct {
+		user, id string
+		q        *quotedTweet
+		done     bool // q is valid (either cached or fetched)
+	}
+	quotes := make(map[*gofeed.Item]*quote)
+	byID := make(map[string]*quote)
+	var fetch []*quote
+	for _, oi := range items {
+		user, id := quotedStatus(oi.Description, tweetID(itemID(oi)))
+		if id == "" {
+			continue
+		}
+		qt := byID[id]
+		if qt == nil {
+			qt = &quote{user: user, id: id}
+			if qt.q, qt.done = hnd.quotes.get(id, time.Now()); !qt.done {
+				if !budget.take() {
+					continue
+				}
+				fetch = append(fetch, qt)
+			}
+			byID[id] = qt
+		}
+		quotes[oi] = qt
+	}
+
+	if len(fetch) > 0 {
+		if hnd.opts.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, hnd.opts.timeout)
+			defer cancel()
+		}
+		var wg sync.WaitGroup
+		for _, qt := range fetch {
+			wg.Add(1)
+			go func(qt *quote) {
+				defer wg.Done()
+				var err error
+				if qt.q, err = hnd.fetchQuote(ctx, loc, qt.user, qt.id); err != nil {
+					log.Printf("Failed fetching quoted tweet %v: %v", qt.id, err)
+					if ctx.Err() != nil {
+						return // don't cache cancellations
+					}
+				}
+				hnd.quotes.set(qt.id, qt.q, time.Now())
+				qt.done = true
+			}(qt)
+		}
+		wg.Wait()
+	}
+
+	contents := make(map[*gofeed.Item]string)
+	for oi, qt := range quotes {
+		if qt.done && qt.q != nil {
+			contents[oi] = oi.Description + qt.q.html()
+		}
+	}
+	return contents
+}
+
+// fetchQuote fetches and parses the Nitter status page for the tweet with the supplied
+// username and ID from the instance at loc.
+func (hnd *handler) fetchQuote(ctx context.Context, loc *url.URL, user, id string) (*quotedTweet, error) {
+	u := url.URL{Scheme: loc.Scheme, Host: loc.Host, Path: "/" + user + "/status/" + id}
+	log.Print("Fetching ", u.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hnd.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{resp.StatusCode, resp.Status}
+	}
+	return parseQuote(resp.Body, resp.Request.URL)
+}
+
+// parseQuote parses the main tweet from a Nitter status page fetched from loc.
+func parseQuote(r io.Reader, loc *url.URL) (*quotedTweet, error) {
+	root, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	main := findClass(root, "main-tweet")
+	if main == nil {
+		return nil, errors.New("no main tweet")
+	}
+	resolveURLs(main, loc)
+
+	q := &quotedTweet{url: loc.String()}
+	if n := findClass(main, "fullname"); n != nil {
+		q.fullName = strings.TrimSpace(textContent(n))
+	}
+	if n := findClass(main, "username"); n != nil {
+		q.username = strings.TrimSpace(textContent(n))
+	}
+	if q.username == "" {
+		return nil, errors.New("no username")
+	}
+	if n := findClass(main, "tweet-content"); n != nil {
+		var b strings.Builder
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := html.Render(&b, c); err != nil {
+				return nil, err
+			}
+		}
+		q.content = strings.TrimSpace(b.String())
+	}
+	if n := findClass(main, "attachments"); n != nil {
+		walkNodes(n, func(n *html.Node) {
+			switch n.DataAtom {
+			case atom.Img:
+				q.media = append(q.media, attr(n, "src"))
+			case atom.Video:
+				if p := attr(n, "poster"); p != "" {
+					q.media = append(q.media, p)
+				}
+			}
+		})
+	}
+	return q, nil
+}
+
+// walkNodes calls fn for n and each of its descendants in depth-first order.
+func walkNodes(n *html.Node, fn func(n *html.Node)) {
+	fn(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkNodes(c, fn)
+	}
+}
+
+// findClass returns the first element within n (inclusive) with the supplied class.
+func findClass(n *html.Node, class string) *html.Node {
+	if n.Type == html.ElementNode {
+		for _, c := range strings.Fields(attr(n, "class")) {
+			if c == class {
+				return n
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if f := findClass(c, class); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// attr returns the value of n's attribute with the supplied key.
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// textContent returns the concatenated text within n.
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	walkNodes(n, func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+	})
+	return b.String()
+}
+
+// resolveURLs resolves relative URLs in attributes within n against base.
+func resolveURLs(n *html.Node, base *url.URL) {
+	walkNodes(n, func(n *html.Node) {
+		for i, a := range n.Attr {
+			if !urlAttrs[a.Key] || a.Key == "srcset" {
+				continue
+			}
+			if ref, err := url.Parse(a.Val); err == nil {
+				n.Attr[i].Val = base.ResolveReference(ref).String()
+			}
+		}
+	})
+}
@@ -0,0 +1,149 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestQuotedStatus(t *testing.T) {
+	for _, tc := range []struct {
+		content, ownID string
+		user, id       string
+	}{
+		{`Just text`, "1", "", ""},
+		{`<a href="https://nitter.example.org/someuser">@someuser</a>`, "1", "", ""},
+		{`Look<p><a href="https://nitter.example.org/other/status/2#m">nitter.example.org/other/status/2#m</a></p>`,
+			"1", "other", "2"},
+		{`<a href="https://nitter.example.org/me/status/1">self</a>`, "1", "", ""},
+		{`<a href="/a/status/2">a</a> <a href="/b/status/3#m">b</a>`, "1", "b", "3"},
+	} {
+		if user, id := quotedStatus(tc.content, tc.ownID); user != tc.user || id != tc.id {
+			t.Errorf("quotedStatus(%q, %q) = %q, %q; want %q, %q",
+				tc.content, tc.ownID, user, id, tc.user, tc.id)
+		}
+	}
+}
+
+// statusPage returns a minimal Nitter status page for a tweet.
+func statusPage(name, user, content, media string) string {
+	return `<html><body><div class="timeline-item thread"><div class="tweet-body">` +
+		`<a class="fullname" href="/other">Not This One</a></div></div>` +
+		`<div id="m" class="main-tweet"><div class="timeline-item"><div class="tweet-body">` +
+		`<div class="tweet-header"><a class="fullname" href="/` + user + `">` + name + `</a>` +
+		`<a class="username" href="/` + user + `">@` + user + `</a></div>` +
+		`<div class="tweet-content media-body" dir="auto">` + content + `</div>` +
+		`<div class="attachments">` + media + `</div>` +
+		`</div></div></div></body></html>`
+}
+
+func TestParseQuote(t *testing.T) {
+	loc, _ := url.Parse("https://nitter.example.org/other/status/2")
+	page := statusPage("Other & Co", "other", `Hi <a href="/someuser">@someuser</a>`,
+		`<a class="still-image" href="/pic/orig/media%2FAbC.jpg"><img src="/pic/media%2FAbC.jpg?name=small"></a>`+
+			`<video poster="/pic/tweet_video_thumb%2FDeF.jpg"></video>`)
+	q, err := parseQuote(strings.NewReader(page), loc)
+	if err != nil {
+		t.Fatal("parseQuote failed:", err)
+	}
+	want := &quotedTweet{
+		url:      loc.String(),
+		fullName: "Other & Co",
+		username: "@other",
+		content:  `Hi <a href="https://nitter.example.org/someuser">@someuser</a>`,
+		media: []string{
+			"https://nitter.example.org/pic/media%2FAbC.jpg?name=small",
+			"https://nitter.example.org/pic/tweet_video_thumb%2FDeF.jpg",
+		},
+	}
+	if !reflect.DeepEqual(q, want) {
+		t.Errorf("parseQuote returned %+v; want %+v", q, want)
+	}
+	if got, want := q.html(), `<blockquote><p><a href="`+loc.String()+`">Other &amp; Co (@other)</a>:</p>`; !strings.HasPrefix(got, want) {
+		t.Errorf("html() = %q; want prefix %q", got, want)
+	}
+
+	if _, err := parseQuote(strings.NewReader("<html><body>Error</body></html>"), loc); err == nil {
+		t.Error("parseQuote unexpectedly succeeded for page without tweet")
+	}
+}
+
+func TestExpandQuote(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		switch req.URL.Path {
+		case "/other/status/2", "/other/status/3":
+			fmt.Fprint(w, statusPage("Other", "other", "Quoted "+req.URL.Path[len(req.URL.Path)-1:], ""))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	hnd, err := newHandler("", srv.URL, handlerOptions{format: atomFormat})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+	loc, _ := url.Parse(srv.URL + "/me/rss")
+	ctx := context.Background()
+	item := func(id int, quoted string) *gofeed.Item {
+		link := fmt.Sprintf("%s/me/status/%d#m", srv.URL, id)
+		return &gofeed.Item{
+			Description: `<a href="` + srv.URL + `/other/status/` + quoted + `#m">link</a>`,
+			Link:        link,
+			GUID:        link,
+		}
+	}
+	check := func(desc string, items []*gofeed.Item, budget *quoteBudget, want map[int]string, fetched int32) {
+		t.Helper()
+		atomic.StoreInt32(&fetches, 0)
+		contents := hnd.expandQuotes(ctx, items, loc, budget)
+		got := make(map[int]string)
+		for i, oi := range items {
+			if c, ok := contents[oi]; ok {
+				if !strings.HasPrefix(c, oi.Description) {
+					t.Errorf("%v: content %q doesn't start with original %q", desc, c, oi.Description)
+				}
+				got[i] = c[len(oi.Description):]
+			}
+		}
+		for i, w := range want {
+			if !strings.Contains(got[i], w) {
+				t.Errorf("%v: item %d has quote %q; want %q", desc, i, got[i], w)
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("%v: got quotes for %d item(s); want %d", desc, len(got), len(want))
+		}
+		if n := atomic.LoadInt32(&fetches); n != fetched {
+			t.Errorf("%v: server got %d request(s); want %d", desc, n, fetched)
+		}
+	}
+
+	// Tweet 2 is quoted twice but should only be fetched once. Tweet 3 isn't fetched
+	// since the budget is exhausted.
+	items := []*gofeed.Item{item(10, "2"), item(11, "2"), item(12, "4"), item(13, "3")}
+	check("first", items, newQuoteBudget(2),
+		map[int]string{0: "<p>Quoted 2</p>", 1: "<p>Quoted 2</p>"}, 2)
+
+	// Tweet 2 and the failure for tweet 4 should be cached now.
+	check("second", items, newQuoteBudget(1),
+		map[int]string{0: "<p>Quoted 2</p>", 1: "<p>Quoted 2</p>", 3: "<p>Quoted 3</p>"}, 1)
+
+	// A budget is shared across calls, e.g. for merged feeds.
+	budget := newQuoteBudget(1)
+	check("shared 1", []*gofeed.Item{item(20, "5")}, budget, map[int]string{}, 1)
+	check("shared 2", []*gofeed.Item{item(21, "6")}, budget, map[int]string{}, 0)
+}
@@ -0,0 +1,195 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// retweetTitlePrefix is used by Nitter at the start of retweets' titles, e.g. "RT by @someuser: ...".
+const retweetTitlePrefix = "RT by @"
+
+// retweetMode describes how retweets are handled.
+type retweetMode string
+
+const (
+	retweetsKeep     retweetMode = "keep"     // include retweets as supplied by Nitter
+	retweetsDrop     retweetMode = "drop"     // omit retweets
+	retweetsAnnotate retweetMode = "annotate" // prefix retweets' titles with "RT @author:"
+)
+
+// parseRetweetMode returns the retweetMode named by s.
+func parseRetweetMode(s string) (retweetMode, error) {
+	switch m := retweetMode(strings.ToLower(s)); m {
+	case retweetsKeep, retweetsDrop, retweetsAnnotate:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown retweet mode %q", s)
+	}
+}
+
+// feedUsers returns the lowercase usernames in user, e.g. ["foo", "bar"] for "Foo,bar/media".
+func feedUsers(user string) []string {
+	if i := strings.IndexByte(user, '/'); i >= 0 {
+		user = user[:i]
+	}
+	return strings.Split(strings.ToLower(user), ",")
+}
+
+// hasUser returns true if name (e.g. "@SomeUser" or "someuser") is in users.
+func hasUser(users []string, name string) bool {
+	name = strings.ToLower(strings.TrimPrefix(name, "@"))
+	for _, u := range users {
+		if name == u {
+			return true
+		}
+	}
+	return false
+}
+
+// itemAuthor returns the author of the tweet in oi, e.g. "@someuser".
+// For retweets, this is the original author rather than the retweeting user.
+func itemAuthor(oi *gofeed.Item) string {
+	// Nitter uses <dc:creator> for the original author.
+	if oi.DublinCoreExt != nil && len(oi.DublinCoreExt.Creator) > 0 && oi.DublinCoreExt.Creator[0] != "" {
+		return oi.DublinCoreExt.Creator[0]
+	}
+	if oi.Author != nil {
+		return oi.Author.Name
+	}
+	return ""
+}
+
+// retweeter returns the lowercase username from a title starting with retweetTitlePrefix,
+// e.g. "someuser" for "RT by @SomeUser: ...". An empty string is returned for other titles.
+func retweeter(title string) string {
+	if !strings.HasPrefix(title, retweetTitlePrefix) {
+		return ""
+	}
+	rest := title[len(retweetTitlePrefix):]
+	if i := strings.Index(rest, ":"); i > 0 {
+		return strings.ToLower(rest[:i])
+	}
+	return ""
+}
+
+// marksRetweets returns true if items seem to come from a newer Nitter version that adds
+// retweetTitlePrefix to retweets' titles, i.e. if any of their titles start with it.
+// Older versions just use a different <dc:creator> for retweets.
+func marksRetweets(items []*gofeed.Item) bool {
+	for _, oi := range items {
+		if strings.HasPrefix(oi.Title, retweetTitlePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRetweet returns true if oi is a retweet in a feed containing tweets from users
+// (as returned by feedUsers). marked should be the result of marksRetweets for the feed's
+// items; if it's false, items by other authors are also treated as retweets.
+func isRetweet(oi *gofeed.Item, users []string, marked bool) bool {
+	if strings.HasPrefix(oi.Title, retweetTitlePrefix) {
+		return true
+	}
+	if marked {
+		return false
+	}
+	author := itemAuthor(oi)
+	return author != "" && !hasUser(users, author)
+}
+
+// linkUser returns the lowercase username from a status URL like
+// "https://nitter.example.org/SomeUser/status/123#m", or an empty string if link isn't one.
+func linkUser(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 3 || parts[1] != "status" || parts[0] == "i" {
+		return ""
+	}
+	return strings.ToLower(parts[0])
+}
+
+// isForeign returns true if oi doesn't seem to belong in a feed containing tweets from users
+// (as returned by feedUsers), i.e. it was neither written nor retweeted by one of them.
+// Buggy Nitter instances sometimes include unrelated tweets from other feeds.
+// marked should be the result of marksRetweets for the feed's items.
+func isForeign(oi *gofeed.Item, users []string, marked bool) bool {
+	if rt := retweeter(oi.Title); rt != "" {
+		return !hasUser(users, rt)
+	}
+	// If the feed doesn't mark retweets, items by other authors can't be distinguished
+	// from retweets, so they're treated as retweets (per isRetweet) rather than as foreign.
+	if isRetweet(oi, users, marked) {
+		return false
+	}
+	if author := itemAuthor(oi); author != "" && !hasUser(users, author) {
+		return true
+	}
+	if lu := linkUser(oi.Link); lu != "" && !hasUser(users, lu) {
+		return true
+	}
+	return false
+}
+
+// minCheckedItems is the minimum number of items needed for checkForeign to reject a feed.
+const minCheckedItems = 5
+
+// foreignError is returned by checkForeign when a feed contains too many foreign items.
+type foreignError struct {
+	foreign, total int          // number of foreign items and total items
+	ff             *fetchedFeed // rejected feed (set by fetchFrom)
+}
+
+func (e *foreignError) Error() string {
+	return fmt.Sprintf("%v of %v items are from other users", e.foreign, e.total)
+}
+
+// leastForeign returns whichever of best (which may be nil) and err has the lowest
+// fraction of foreign items. best is returned if err isn't a *foreignError with a feed.
+func leastForeign(best *foreignError, err error) *foreignError {
+	fe, ok := err.(*foreignError)
+	if !ok || fe.ff == nil {
+		return best
+	}
+	if best == nil || fe.foreign*best.total < best.foreign*fe.total {
+		return fe
+	}
+	return best
+}
+
+// checkForeign returns a *foreignError if more than maxRatio of the items in of, fetched for
+// user, seem to be foreign per isForeign. Retweets by the requested user(s) are allowed.
+// Feeds with fewer than minCheckedItems items aren't checked, and a maxRatio of 0 disables
+// checking.
+func checkForeign(of *gofeed.Feed, user string, maxRatio float64) error {
+	if maxRatio <= 0 || len(of.Items) < minCheckedItems {
+		return nil
+	}
+	users := feedUsers(user)
+	marked := marksRetweets(of.Items)
+	var n int
+	for _, oi := range of.Items {
+		if isForeign(oi, users, marked) {
+			n++
+		}
+	}
+	if float64(n)/float64(len(of.Items)) > maxRatio {
+		return &foreignError{foreign: n, total: len(of.Items)}
+	}
+	return nil
+}
+
+// annotateRetweet returns a title for a retweet of a tweet by author (e.g. "@someuser"),
+// replacing Nitter's "RT by @retweeter: " prefix with "RT @author: ".
+func annotateRetweet(title, author string) string {
+	return "RT @" + strings.TrimPrefix(author, "@") + ": " + tweetText(title)
+}
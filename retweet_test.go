@@ -0,0 +1,180 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/extensions"
+)
+
+// newItem returns an item with the supplied title and <dc:creator>.
+func newItem(title, creator string) *gofeed.Item {
+	oi := &gofeed.Item{Title: title}
+	if creator != "" {
+		oi.DublinCoreExt = &ext.DublinCoreExtension{Creator: []string{creator}}
+	}
+	return oi
+}
+
+func TestIsRetweet(t *testing.T) {
+	for _, tc := range []struct {
+		title, creator, user string
+		marked               bool // feed marks retweets with "RT by"
+		retweet, foreign     bool
+	}{
+		{"Hello", "@someuser", "someuser", true, false, false},
+		{"Hello", "@SomeUser", "someuser/media", true, false, false},
+		{"Hello", "", "someuser", true, false, false},
+		{"RT by @someuser: Hello", "@other", "someuser", true, true, false},
+		{"RT by @SomeUser: Hello", "@other", "foo,someuser", true, true, false},
+		{"Hello", "@other", "someuser", false, true, false}, // older Nitter without "RT by"
+		{"Hello", "@other", "someuser", true, false, true},
+		{"RT by @third: Hello", "@other", "someuser", true, true, true},
+		{"R to @other: Sure", "@someuser", "someuser", true, false, false},
+	} {
+		oi := newItem(tc.title, tc.creator)
+		users := feedUsers(tc.user)
+		if got := isRetweet(oi, users, tc.marked); got != tc.retweet {
+			t.Errorf("isRetweet(%q by %q, %q, %v) = %v; want %v",
+				tc.title, tc.creator, users, tc.marked, got, tc.retweet)
+		}
+		if got := isForeign(oi, users, tc.marked); got != tc.foreign {
+			t.Errorf("isForeign(%q by %q, %q, %v) = %v; want %v",
+				tc.title, tc.creator, users, tc.marked, got, tc.foreign)
+		}
+	}
+}
+
+func TestItemAuthor(t *testing.T) {
+	oi := newItem("RT by @someuser: Hello", "@other")
+	oi.Author = &gofeed.Person{Name: "@someuser"}
+	if got, want := itemAuthor(oi), "@other"; got != want {
+		t.Errorf("itemAuthor() = %q; want %q", got, want)
+	}
+	oi.DublinCoreExt = nil
+	if got, want := itemAuthor(oi), "@someuser"; got != want {
+		t.Errorf("itemAuthor() without creator = %q; want %q", got, want)
+	}
+}
+
+func TestAnnotateRetweet(t *testing.T) {
+	for _, tc := range []struct{ title, author, want string }{
+		{"RT by @someuser: Hello there", "@other", "RT @other: Hello there"},
+		{"Hello there", "other", "RT @other: Hello there"},
+	} {
+		if got := annotateRetweet(tc.title, tc.author); got != tc.want {
+			t.Errorf("annotateRetweet(%q, %q) = %q; want %q", tc.title, tc.author, got, tc.want)
+		}
+	}
+}
+
+func TestLinkUser(t *testing.T) {
+	for _, tc := range []struct{ link, want string }{
+		{"https://nitter.example.org/SomeUser/status/123#m", "someuser"},
+		{"https://nitter.example.org/someuser/status/123/photo/1", "someuser"},
+		{"https://nitter.example.org/i/web/status/123", ""},
+		{"https://nitter.example.org/someuser", ""},
+		{"", ""},
+	} {
+		if got := linkUser(tc.link); got != tc.want {
+			t.Errorf("linkUser(%q) = %q; want %q", tc.link, got, tc.want)
+		}
+	}
+}
+
+func TestCheckForeign(t *testing.T) {
+	// items returns own items by someuser followed by foreign items by other. If marked is
+	// true, the first own item is a retweet marked with "RT by".
+	items := func(own, foreign int, marked bool) []*gofeed.Item {
+		var items []*gofeed.Item
+		for i := 0; i < own; i++ {
+			oi := newItem("Hello", "@someuser")
+			oi.Link = "https://nitter.example.org/someuser/status/1#m"
+			if i == 0 && marked {
+				oi = newItem("RT by @someuser: Hello", "@third")
+				oi.Link = "https://nitter.example.org/third/status/3#m"
+			}
+			items = append(items, oi)
+		}
+		for i := 0; i < foreign; i++ {
+			oi := newItem("Unrelated", "@other")
+			oi.Link = "https://nitter.example.org/other/status/2#m"
+			items = append(items, oi)
+		}
+		return items
+	}
+	for _, tc := range []struct {
+		own, foreign int
+		marked       bool
+		maxRatio     float64
+		ok           bool
+	}{
+		{10, 0, true, 0.5, true},
+		{5, 5, true, 0.5, true},
+		{4, 6, true, 0.5, false},
+		{2, 8, true, 0.5, false},
+		{0, 10, true, 0, true},  // disabled
+		{1, 3, true, 0.5, true}, // too few items
+		// Items by other users can't be distinguished from unmarked retweets.
+		{2, 8, false, 0.5, true},
+	} {
+		of := &gofeed.Feed{Items: items(tc.own, tc.foreign, tc.marked)}
+		if err := checkForeign(of, "someuser", tc.maxRatio); (err == nil) != tc.ok {
+			t.Errorf("checkForeign with %v own and %v foreign item(s) (marked %v) and max %v returned %v",
+				tc.own, tc.foreign, tc.marked, tc.maxRatio, err)
+		}
+	}
+
+	// Retweets by the requested user are allowed.
+	var of gofeed.Feed
+	for i := 0; i < 10; i++ {
+		oi := newItem("RT by @someuser: Hi", "@other")
+		oi.Link = "https://nitter.example.org/other/status/3#m"
+		of.Items = append(of.Items, oi)
+	}
+	if err := checkForeign(&of, "someuser", 0.5); err != nil {
+		t.Error("checkForeign rejected retweets: ", err)
+	}
+}
+
+func TestGetFeed_AllForeign(t *testing.T) {
+	// feed returns RSS items with a retweet and own tweets by someuser followed by
+	// foreign tweets by other.
+	feed := func(own, foreign int) string {
+		s := `<item><title>RT by @someuser: Hi</title><dc:creator>@third</dc:creator>` +
+			`<link>https://nitter.example.org/third/status/99#m</link></item>`
+		for i := 0; i < own; i++ {
+			s += rssItem("someuser", i, "Mon, 01 Jan 2024 10:00:00 GMT")
+		}
+		for i := 0; i < foreign; i++ {
+			s += rssItem("other", 100+i, "Mon, 01 Jan 2024 10:00:00 GMT")
+		}
+		return s
+	}
+	worse := newFakeNitter(map[string]string{"/someuser/rss": feed(1, 5)})
+	defer worse.Close()
+	better := newFakeNitter(map[string]string{"/someuser/rss": feed(3, 3)})
+	defer better.Close()
+
+	hnd, err := newHandler("", worse.URL+","+better.URL, handlerOptions{
+		format: atomFormat, maxForeign: 0.2, circuitFailures: 3, cacheTTL: time.Minute, cacheSize: 10,
+	})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+	ff, _, err := hnd.getFeed(context.Background(), "someuser", "")
+	if err != nil {
+		t.Fatal("getFeed failed:", err)
+	}
+	if got, want := ff.instance.String(), better.URL; got != want {
+		t.Errorf("getFeed used feed from %v; want %v", got, want)
+	}
+	if _, state := hnd.cache.get(cacheKey("someuser", ""), time.Now()); state != cacheMiss {
+		t.Error("Rejected feed was cached")
+	}
+}
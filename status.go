@@ -0,0 +1,156 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const recentRequests = 50 // number of recent feed requests shown on status page
+
+// requestRecord describes a feed request for the status page.
+type requestRecord struct {
+	Time     time.Time  `json:"time"`
+	Path     string     `json:"path"`
+	Format   feedFormat `json:"format,omitempty"`
+	Status   int        `json:"status"`
+	Instance string     `json:"instance,omitempty"` // instance that served the feed
+	Cached   bool       `json:"cached"`             // feed was served from the cache
+}
+
+// requestLog holds the most recent requestRecords.
+// It's safe for concurrent use.
+type requestLog struct {
+	mu   sync.Mutex
+	recs []requestRecord // circular buffer
+	next int             // index in recs for next record
+	full bool            // recs has wrapped
+}
+
+func newRequestLog(size int) *requestLog {
+	return &requestLog{recs: make([]requestRecord, size)}
+}
+
+// add adds rec to the log, replacing the oldest record if the log is full.
+func (rl *requestLog) add(rec requestRecord) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if len(rl.recs) == 0 {
+		return
+	}
+	rl.recs[rl.next] = rec
+	if rl.next = (rl.next + 1) % len(rl.recs); rl.next == 0 {
+		rl.full = true
+	}
+}
+
+// get returns the records in the log, newest first.
+func (rl *requestLog) get() []requestRecord {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	n := rl.next
+	if rl.full {
+		n = len(rl.recs)
+	}
+	recs := make([]requestRecord, n)
+	for i := range recs {
+		recs[i] = rl.recs[(rl.next-1-i+len(rl.recs))%len(rl.recs)]
+	}
+	return recs
+}
+
+// status is written by handler.serveStatus.
+type status struct {
+	Time      time.Time        `json:"time"`
+	Instances []instanceStatus `json:"instances"`
+	Requests  []requestRecord  `json:"requests"`
+}
+
+// serveStatus writes a page describing the health of hnd's instances and recent requests.
+// JSON is written if req's path ends in ".json", its "format" query parameter is "json", or
+// it prefers JSON per its Accept header. HTML is written otherwise.
+func (hnd *handler) serveStatus(w http.ResponseWriter, req *http.Request) {
+	now := time.Now()
+	st := status{
+		Time:      now,
+		Instances: hnd.health.status(hnd.instances, now),
+		Requests:  hnd.recent.get(),
+	}
+
+	if strings.HasSuffix(req.URL.Path, ".json") || req.URL.Query().Get("format") == "json" ||
+		acceptFormat(req.Header.Get("Accept"), "") == jsonFormat {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(&st); err != nil {
+			log.Print("Failed writing status: ", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	if err := statusTemplate.Execute(w, &st); err != nil {
+		log.Print("Failed writing status: ", err)
+	}
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	// fmtTime formats a time.Time or *time.Time.
+	"fmtTime": func(v interface{}) string {
+		var t time.Time
+		switch tv := v.(type) {
+		case time.Time:
+			t = tv
+		case *time.Time:
+			if tv != nil {
+				t = *tv
+			}
+		}
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>nitter-rss-proxy status</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: solid 1px #ccc; padding: 2px 6px; text-align: left; }
+.open { background-color: #fdd; }
+.half-open { background-color: #ffd; }
+</style>
+</head>
+<body>
+<h2>Instances</h2>
+<table>
+<tr><th>URL</th><th>Circuit</th><th>Failures</th><th>Last success</th><th>Last failure</th>
+<th>Last status</th><th>Last error</th><th>Avg latency</th></tr>
+{{- range .Instances}}
+<tr class="{{.State}}"><td>{{.URL}}</td><td>{{.State}}</td><td>{{.Failures}}</td>
+<td>{{fmtTime .LastSuccess}}</td><td>{{fmtTime .LastFailure}}</td>
+<td>{{if .LastStatus}}{{.LastStatus}}{{end}}</td><td>{{.LastError}}</td>
+<td>{{if .LatencyMs}}{{.LatencyMs}} ms{{end}}</td></tr>
+{{- end}}
+</table>
+<h2>Recent requests</h2>
+<table>
+<tr><th>Time</th><th>Path</th><th>Format</th><th>Status</th><th>Instance</th><th>Cached</th></tr>
+{{- range .Requests}}
+<tr><td>{{fmtTime .Time}}</td><td>{{.Path}}</td><td>{{.Format}}</td><td>{{.Status}}</td>
+<td>{{.Instance}}</td><td>{{if .Cached}}yes{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
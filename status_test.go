@@ -0,0 +1,57 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRequestLog(t *testing.T) {
+	rl := newRequestLog(3)
+	paths := func() []string {
+		var ps []string
+		for _, rec := range rl.get() {
+			ps = append(ps, rec.Path)
+		}
+		return ps
+	}
+
+	if got := paths(); len(got) != 0 {
+		t.Errorf("Empty log returned %q", got)
+	}
+	rl.add(requestRecord{Path: "a"})
+	rl.add(requestRecord{Path: "b"})
+	if got, want := paths(), []string{"b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Log returned %q; want %q", got, want)
+	}
+	rl.add(requestRecord{Path: "c"})
+	rl.add(requestRecord{Path: "d"})
+	if got, want := paths(), []string{"d", "c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Log returned %q; want %q", got, want)
+	}
+}
+
+func TestServeStatus(t *testing.T) {
+	const inst = "https://nitter.example.org"
+	hnd, err := newHandler("", inst, handlerOptions{format: atomFormat, circuitFailures: 1})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+	hnd.recent.add(requestRecord{Path: "/someuser", Status: http.StatusOK, Instance: inst})
+
+	for _, path := range []string{"/status", "/status.json"} {
+		w := httptest.NewRecorder()
+		hnd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%v returned %v", path, w.Code)
+		}
+		if body := w.Body.String(); !strings.Contains(body, inst) || !strings.Contains(body, "/someuser") {
+			t.Errorf("%v didn't include instance and request:\n%s", path, body)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// originalTarget is the rewriteTarget name used to point URLs at the instance that served a feed.
+const originalTarget = "original"
+
+// rewriteTarget describes where rewritten URLs point.
+// URLs are first rewritten to point at twitter.com and *.twimg.com and then mapped to the target.
+// A nil *rewriteTarget leaves URLs pointing at twitter.com.
+type rewriteTarget struct {
+	host     string   // replaces twitter.com if non-empty, e.g. "x.com"
+	nitter   *url.URL // Nitter instance that URLs should point at
+	original bool     // point URLs at the Nitter instance that served the feed
+	media    *url.URL // proxy's media endpoint that *.twimg.com URLs should point at
+}
+
+// parseRewriteTarget parses s, which may be "twitter.com", "x.com", "original"
+// (for the instance that served the feed), or the URL of a Nitter instance.
+func parseRewriteTarget(s string) (*rewriteTarget, error) {
+	switch strings.ToLower(s) {
+	case "", "twitter", "twitter.com":
+		return nil, nil
+	case "x", "x.com":
+		return &rewriteTarget{host: "x.com"}, nil
+	case originalTarget:
+		return &rewriteTarget{original: true}, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("bad rewrite target %q", s)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawQuery, u.Fragment = "", ""
+	return &rewriteTarget{nitter: u}, nil
+}
+
+// forLoc returns the target to use for a feed fetched from loc.
+// If t is originalTarget, a target pointing at loc's instance is returned.
+func (t *rewriteTarget) forLoc(loc *url.URL) *rewriteTarget {
+	if t == nil || !t.original {
+		return t
+	}
+	if loc == nil {
+		return nil
+	}
+	return &rewriteTarget{nitter: &url.URL{Scheme: loc.Scheme, Host: loc.Host}}
+}
+
+// withMedia returns a copy of t that maps *.twimg.com URLs to the media endpoint at base,
+// e.g. "https://proxy.example.org/media". It should be called after forLoc.
+func (t *rewriteTarget) withMedia(base *url.URL) *rewriteTarget {
+	nt := &rewriteTarget{}
+	if t != nil {
+		*nt = *t
+	}
+	nt.media = base
+	return nt
+}
+
+// rewrite rewrites a Nitter URL (e.g. an item's link or GUID) to point at t.
+func (t *rewriteTarget) rewrite(orig string) string {
+	return t.mapURL(rewriteTwitterURL(orig))
+}
+
+// canonicalURLRegexp matches twitter.com and *.twimg.com URLs (with optional schemes)
+// as produced by rewritePatterns and rewriteTwitterURL.
+var canonicalURLRegexp = regexp.MustCompile(`\b(?:https?://)?(?:twitter\.com|(?:pbs|video)\.twimg\.com)` +
+	`/[-_.~!$&'()*+,;=:@/?%#a-zA-Z0-9]*`)
+
+// mapAll maps all twitter.com and *.twimg.com URLs in s to t.
+func (t *rewriteTarget) mapAll(s string) string {
+	if t == nil || t.original {
+		return s
+	}
+	return canonicalURLRegexp.ReplaceAllStringFunc(s, t.mapURL)
+}
+
+// mapURL maps orig, a twitter.com or *.twimg.com URL, to t.
+// The scheme may be omitted, e.g. "twitter.com/someuser", in which case the returned URL
+// also lacks a scheme. Other URLs are returned unchanged.
+func (t *rewriteTarget) mapURL(orig string) string {
+	if t == nil || t.original {
+		return orig
+	}
+	s := orig
+	noScheme := !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://")
+	if noScheme {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return orig
+	}
+
+	switch {
+	case strings.HasSuffix(u.Host, ".twimg.com") && t.media != nil:
+		// The media endpoint uses e.g. "/media/pbs.twimg.com/media/AbC.jpg?name=small".
+		m := *t.media
+		m.Path += "/" + u.Host + u.Path
+		m.RawPath = ""
+		m.RawQuery = u.RawQuery
+		s = m.String()
+	case u.Host == "twitter.com" && t.host != "":
+		u.Host = t.host
+		s = u.String()
+	case u.Host == "twitter.com" && t.nitter != nil:
+		u.Scheme, u.Host = t.nitter.Scheme, t.nitter.Host
+		u.Path = t.nitter.Path + u.Path
+		s = u.String()
+	case strings.HasSuffix(u.Host, ".twimg.com") && t.nitter != nil:
+		// Nitter proxies media at e.g. "/pic/media%2FAbC.jpg" (for pbs.twimg.com)
+		// or "/pic/video.twimg.com%2Ftweet_video%2FAbC.mp4".
+		// Original-resolution images are at e.g. "/pic/orig/media%2FAbC.jpg".
+		p := strings.TrimPrefix(u.Path, "/")
+		pre := "/pic/"
+		if u.Host == "pbs.twimg.com" {
+			if f := u.Query().Get("format"); f != "" && path.Ext(p) == "" {
+				p += "." + f
+			}
+			if u.Query().Get("name") == string(imageSizeOrig) {
+				pre += "orig/"
+			}
+		} else {
+			p = u.Host + "/" + p
+		}
+		s = t.nitter.String() + pre + url.PathEscape(p)
+	default:
+		return orig
+	}
+
+	if noScheme {
+		s = s[strings.Index(s, "://")+3:]
+	}
+	return s
+}
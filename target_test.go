@@ -0,0 +1,66 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRewriteTarget(t *testing.T) {
+	const (
+		status = "https://twitter.com/someuser/status/123"
+		media  = "https://pbs.twimg.com/media/AbC?format=jpg"
+		video  = "https://video.twimg.com/tweet_video/AbC.mp4"
+		other  = "https://example.org/foo"
+	)
+	loc, _ := url.Parse("http://nitter.example.net/someuser/rss")
+	for _, tc := range []struct {
+		target, orig, want string
+	}{
+		{"twitter.com", status, status},
+		{"twitter.com", media, media},
+		{"x.com", status, "https://x.com/someuser/status/123"},
+		{"x.com", "twitter.com/someuser", "x.com/someuser"},
+		{"x.com", media, media},
+		{"https://nitter.example.org/", status, "https://nitter.example.org/someuser/status/123"},
+		{"https://nitter.example.org", media, "https://nitter.example.org/pic/media%2FAbC.jpg"},
+		{"https://nitter.example.org", video,
+			"https://nitter.example.org/pic/video.twimg.com%2Ftweet_video%2FAbC.mp4"},
+		{"https://example.org/nitter", "twitter.com/someuser", "example.org/nitter/someuser"},
+		{"https://nitter.example.org", other, other},
+		{"original", status, "http://nitter.example.net/someuser/status/123"},
+	} {
+		target, err := parseRewriteTarget(tc.target)
+		if err != nil {
+			t.Errorf("parseRewriteTarget(%q) failed: %v", tc.target, err)
+			continue
+		}
+		if got := target.forLoc(loc).mapURL(tc.orig); got != tc.want {
+			t.Errorf("%q: mapURL(%q) = %q; want %q", tc.target, tc.orig, got, tc.want)
+		}
+	}
+
+	for _, s := range []string{"bogus", "ftp://example.org", "https://"} {
+		if _, err := parseRewriteTarget(s); err == nil {
+			t.Errorf("parseRewriteTarget(%q) unexpectedly succeeded", s)
+		}
+	}
+}
+
+func TestRewriteContent_Target(t *testing.T) {
+	loc, _ := url.Parse("https://nitter.net/user/status/123")
+	target, _ := parseRewriteTarget("https://nitter.example.org")
+	const (
+		orig = `<a href="https://nitter.net/foo/status/12345#m">nitter.net/foo/status/123…</a>` +
+			`<img src="https://nitter.net/pic/media%2FArpx24jXoAUzkc9.jpg" />`
+		want = `<a href="https://nitter.example.org/foo/status/12345">nitter.example.org/foo/status/123…</a>` +
+			`<img src="https://nitter.example.org/pic/media%2FArpx24jXoAUzkc9.jpg" />`
+	)
+	if got, err := rewriteContent(orig, loc, target, imageSizeDefault); err != nil {
+		t.Errorf("rewriteContent(%q) failed: %v", orig, err)
+	} else if got != want {
+		t.Errorf("rewriteContent(%q) = %q; want %q", orig, got, want)
+	}
+}
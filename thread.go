@@ -0,0 +1,97 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// threadSeparator is inserted between tweets' content in folded threads.
+const threadSeparator = "<hr />"
+
+// threadPath returns the path to fetch for user (e.g. "someuser") when folding threads.
+// Nitter omits self-replies from users' main timelines, so "someuser/with_replies" is used
+// instead. Other paths (e.g. "someuser/media") are returned unchanged.
+func threadPath(user string) string {
+	if strings.IndexByte(user, '/') >= 0 {
+		return user
+	}
+	return user + "/with_replies"
+}
+
+// replyTo returns the lowercase username from a title starting with replyTitlePrefix,
+// e.g. "someuser" for "R to @SomeUser: ...". An empty string is returned for other titles.
+func replyTo(title string) string {
+	if !strings.HasPrefix(title, replyTitlePrefix) {
+		return ""
+	}
+	rest := title[len(replyTitlePrefix):]
+	if i := strings.Index(rest, ":"); i > 0 {
+		return strings.ToLower(rest[:i])
+	}
+	return ""
+}
+
+// laterID returns true if tweet ID a is greater than b.
+// Tweet IDs are snowflakes, so later tweets have larger IDs.
+func laterID(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
+
+// foldThreads returns items (newest first, as supplied by Nitter) with chains of consecutive
+// self-replies folded into their root tweets. A tweet continues a thread if it replies to the
+// previous tweet's author, was written by that author, and has a larger status ID.
+// Folded items take the root's title, link, and GUID, and contain the thread's content in order.
+func foldThreads(items []*gofeed.Item) []*gofeed.Item {
+	out := make([]*gofeed.Item, len(items))
+	var n int // number of items in out, which is filled from the end
+	var root *gofeed.Item
+	var prev *gofeed.Item
+	var parts []string
+	var folded bool
+
+	flush := func() {
+		if root == nil {
+			return
+		}
+		if folded {
+			orig := root
+			root = new(gofeed.Item)
+			*root = *orig
+			root.Description = strings.Join(parts, threadSeparator)
+			root.UpdatedParsed = prev.PublishedParsed
+			root.Updated = prev.Published
+		}
+		n++
+		out[len(out)-n] = root
+		root, prev, parts, folded = nil, nil, nil, false
+	}
+
+	for i := len(items) - 1; i >= 0; i-- {
+		oi := items[i]
+		if prev != nil && !strings.HasPrefix(prev.Title, retweetTitlePrefix) {
+			author := strings.ToLower(strings.TrimPrefix(itemAuthor(prev), "@"))
+			if author != "" && replyTo(oi.Title) == author &&
+				hasUser([]string{author}, itemAuthor(oi)) &&
+				laterID(tweetID(itemID(oi)), tweetID(itemID(prev))) {
+				parts = append(parts, oi.Description)
+				prev = oi
+				folded = true
+				continue
+			}
+		}
+		flush()
+		root, prev, parts = oi, oi, []string{oi.Description}
+	}
+	flush()
+	return out[len(out)-n:]
+}
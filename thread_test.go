@@ -0,0 +1,157 @@
+// Copyright 2026 Daniel Erat.
+// All rights reserved.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestThreadPath(t *testing.T) {
+	for _, tc := range []struct{ user, want string }{
+		{"foo", "foo/with_replies"},
+		{"foo/media", "foo/media"},
+		{"foo/with_replies", "foo/with_replies"},
+	} {
+		if got := threadPath(tc.user); got != tc.want {
+			t.Errorf("threadPath(%q) = %q; want %q", tc.user, got, tc.want)
+		}
+	}
+}
+
+// threadItem returns an item for a tweet with the supplied title by author.
+func threadItem(author string, id int, title string) *gofeed.Item {
+	link := fmt.Sprintf("https://nitter.example.org/%s/status/%d#m", author, id)
+	return &gofeed.Item{
+		Title:       title,
+		Description: fmt.Sprintf("Tweet %d", id),
+		Link:        link,
+		GUID:        link,
+		Author:      &gofeed.Person{Name: "@" + author},
+	}
+}
+
+func TestFoldThreads(t *testing.T) {
+	// Items are newest first, as in Nitter's feeds.
+	items := []*gofeed.Item{
+		threadItem("foo", 8, "R to @foo: 8"),
+		threadItem("foo", 7, "R to @foo: 7"),
+		threadItem("bar", 6, "R to @foo: 6"), // someone else replied
+		threadItem("foo", 5, "R to @foo: 5"),
+		threadItem("foo", 4, "R to @foo: 4"),
+		threadItem("foo", 3, "Root"),
+		threadItem("foo", 2, "R to @bar: 2"), // reply to someone else
+		threadItem("foo", 0, "R to @foo: 0"), // older ID than previous tweet
+		threadItem("foo", 1, "Other"),
+	}
+	var got []string
+	for _, oi := range foldThreads(items) {
+		got = append(got, oi.Title+": "+oi.Description)
+	}
+	want := []string{
+		"R to @foo: 7: Tweet 7" + threadSeparator + "Tweet 8",
+		"R to @foo: 6: Tweet 6",
+		"Root: Tweet 3" + threadSeparator + "Tweet 4" + threadSeparator + "Tweet 5",
+		"R to @bar: 2: Tweet 2",
+		"R to @foo: 0: Tweet 0",
+		"Other: Tweet 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("foldThreads returned %q; want %q", got, want)
+	}
+	if items[5].Description != "Tweet 3" {
+		t.Errorf("foldThreads modified root item's description to %q", items[5].Description)
+	}
+}
+
+// threadRSSItem returns an RSS item for foo's tweet with the supplied ID and title.
+func threadRSSItem(id int, title string) string {
+	return fmt.Sprintf(`<item><title>%s</title><dc:creator>@foo</dc:creator>`+
+		`<description>Tweet %d</description><pubDate>Mon, 01 Jan 2024 10:0%d:00 GMT</pubDate>`+
+		`<guid>https://nitter.example.org/foo/status/%d#m</guid>`+
+		`<link>https://nitter.example.org/foo/status/%d#m</link></item>`,
+		title, id, id, id, id)
+}
+
+func TestServeThreads(t *testing.T) {
+	srv := newFakeNitter(map[string]string{
+		"/foo/with_replies/rss": threadRSSItem(4, "R to @bar: 4") + threadRSSItem(3, "R to @foo: 3") +
+			threadRSSItem(2, "R to @foo: 2") + threadRSSItem(1, "Root"),
+	})
+	defer srv.Close()
+
+	hnd, err := newHandler("", srv.URL, handlerOptions{format: jsonFormat, threads: true})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+	w := httptest.NewRecorder()
+	hnd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/foo returned %v: %s", w.Code, w.Body.String())
+	}
+	var feed struct {
+		Items []struct {
+			ID          string `json:"id"`
+			ContentHTML string `json:"content_html"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal("Failed unmarshaling feed:", err)
+	}
+	if len(feed.Items) != 1 {
+		t.Fatalf("Got %d item(s); want 1: %s", len(feed.Items), w.Body.String())
+	}
+	want := "Tweet 1" + threadSeparator + "Tweet 2" + threadSeparator + "Tweet 3"
+	if it := feed.Items[0]; it.ID != canonicalIDPrefix+"1" || it.ContentHTML != want {
+		t.Errorf("Got item %q with content %q; want %q with %q",
+			it.ID, it.ContentHTML, canonicalIDPrefix+"1", want)
+	}
+}
+
+func TestServeThreads_Validators(t *testing.T) {
+	var mu sync.Mutex
+	items := threadRSSItem(2, "R to @foo: 2") + threadRSSItem(1, "Root")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+			`<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/"><channel>`+
+			`<title>Feed</title><link>https://nitter.example.org/</link>`+items+`</channel></rss>`)
+	}))
+	defer srv.Close()
+
+	hnd, err := newHandler("", srv.URL, handlerOptions{format: atomFormat, threads: true})
+	if err != nil {
+		t.Fatal("Failed creating handler:", err)
+	}
+	w := httptest.NewRecorder()
+	hnd.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/foo returned %v: %s", w.Code, w.Body.String())
+	}
+	etag, mod := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+
+	// When the thread grows, its item keeps the same ID, but the validators should change.
+	mu.Lock()
+	items = threadRSSItem(3, "R to @foo: 3") + items
+	mu.Unlock()
+	for _, hdr := range [][2]string{{"If-None-Match", etag}, {"If-Modified-Since", mod}} {
+		req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		req.Header.Set(hdr[0], hdr[1])
+		w := httptest.NewRecorder()
+		hnd.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("/foo with %v %q returned %v; want %v", hdr[0], hdr[1], w.Code, http.StatusOK)
+		} else if w.Header().Get("ETag") == etag {
+			t.Errorf("/foo with %v %q returned unchanged ETag %q", hdr[0], hdr[1], etag)
+		}
+	}
+}